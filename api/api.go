@@ -3,14 +3,17 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,6 +23,8 @@ import (
 type URL struct {
 	URL      url.URL
 	Settings config.Settings
+	// HttpClient overrides the package level HttpClient for this request.
+	HttpClient *http.Client
 }
 
 var (
@@ -51,18 +56,32 @@ func Action(cs config.Settings, prefix string, suffix ...string) *URL {
 func ActionEndpoint(cs config.Settings, endpoint string) *URL {
 	u := &URL{Settings: cs, URL: url.URL{}}
 	u.URL.Scheme = cs.Scheme
-	u.URL.Host = fmt.Sprintf("%s:%d", cs.Host, cs.Port)
+	u.URL.Host = hostPort(cs)
 	u.URL.Path = fmt.Sprintf("/%s/projects/%s/%s", cs.ApiVersion, cs.ProjectId, endpoint)
 	return u
 }
 
+// hostPort joins the host and port settings, bracketing IPv6 literals.
+func hostPort(cs config.Settings) string {
+	host := strings.TrimSuffix(strings.TrimPrefix(cs.Host, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(int(cs.Port)))
+}
+
 func VersionAction(cs config.Settings) *URL {
 	u := &URL{Settings: cs, URL: url.URL{Scheme: cs.Scheme}}
-	u.URL.Host = fmt.Sprintf("%s:%d", cs.Host, cs.Port)
+	u.URL.Host = hostPort(cs)
 	u.URL.Path = "/version"
 	return u
 }
 
+// Client returns the http.Client the request will be made with.
+func (u *URL) Client() *http.Client {
+	if u.HttpClient != nil {
+		return u.HttpClient
+	}
+	return HttpClient
+}
+
 func (u *URL) QueryAdd(key string, format string, value interface{}) *URL {
 	query := u.URL.Query()
 	query.Add(key, fmt.Sprintf(format, value))
@@ -71,6 +90,11 @@ func (u *URL) QueryAdd(key string, format string, value interface{}) *URL {
 }
 
 func (u *URL) Req(method string, in, out interface{}) (err error) {
+	return u.ReqContext(context.Background(), method, in, out)
+}
+
+// ReqContext is like Req, but gives up when ctx is done.
+func (u *URL) ReqContext(ctx context.Context, method string, in, out interface{}) (err error) {
 	var reqBody io.Reader
 	if in != nil {
 		data, err := json.Marshal(in)
@@ -79,7 +103,7 @@ func (u *URL) Req(method string, in, out interface{}) (err error) {
 		}
 		reqBody = bytes.NewBuffer(data)
 	}
-	response, err := u.Request(method, reqBody)
+	response, err := u.RequestContext(ctx, method, reqBody)
 	if response != nil {
 		defer response.Body.Close()
 	}
@@ -91,9 +115,40 @@ func (u *URL) Req(method string, in, out interface{}) (err error) {
 	return
 }
 
-var MaxRequestRetries = 5
+var (
+	// MaxRequestRetries is used when the Settings don't specify MaxRetries.
+	MaxRequestRetries = 5
+	// RequestRetryBackoff is used when the Settings don't specify RetryBackoff.
+	RequestRetryBackoff = 100 * time.Millisecond
+)
+
+func (u *URL) maxRetries() int {
+	switch {
+	case u.Settings.MaxRetries > 0:
+		return u.Settings.MaxRetries
+	case u.Settings.MaxRetries < 0:
+		return 0
+	}
+	return MaxRequestRetries
+}
+
+// retryDelay smooths out the delays between attempts, growing quadratically
+// from the configured backoff.
+func (u *URL) retryDelay(tries int) time.Duration {
+	backoff := u.Settings.RetryBackoff
+	if backoff <= 0 {
+		backoff = RequestRetryBackoff
+	}
+	return backoff * time.Duration((tries+1)*(tries+1))
+}
 
 func (u *URL) Request(method string, body io.Reader) (response *http.Response, err error) {
+	return u.RequestContext(context.Background(), method, body)
+}
+
+// RequestContext is like Request, but gives up when ctx is done. The
+// configured Timeout applies on top of ctx.
+func (u *URL) RequestContext(ctx context.Context, method string, body io.Reader) (response *http.Response, err error) {
 	var bodyBytes []byte
 	if body == nil {
 		bodyBytes = []byte{}
@@ -104,8 +159,14 @@ func (u *URL) Request(method string, body io.Reader) (response *http.Response, e
 		}
 	}
 
-	request, err := http.NewRequest(method, u.URL.String(), nil)
+	cancel := context.CancelFunc(func() {})
+	if u.Settings.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, u.Settings.Timeout)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, u.URL.String(), nil)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
@@ -119,24 +180,38 @@ func (u *URL) Request(method string, body io.Reader) (response *http.Response, e
 
 	dbg("request:", fmt.Sprintf("%#v\n", request))
 
-	for tries := 0; tries <= MaxRequestRetries; tries++ {
+	retries := u.maxRetries()
+	for tries := 0; tries <= retries; tries++ {
 		request.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
-		response, err = HttpClient.Do(request)
+		response, err = u.Client().Do(request)
 		if err != nil {
 			if err == io.EOF {
 				continue
 			}
+			cancel()
 			return
 		}
 
-		if response.StatusCode == http.StatusServiceUnavailable {
-			delay := (tries + 1) * 10 // smooth out delays from 0-2
-			time.Sleep(time.Duration(delay*delay) * time.Millisecond)
+		if response.StatusCode == http.StatusServiceUnavailable && tries < retries {
+			response.Body.Close()
+			select {
+			case <-time.After(u.retryDelay(tries)):
+			case <-ctx.Done():
+				cancel()
+				return nil, ctx.Err()
+			}
 			continue
 		}
 
 		break
 	}
+	if err != nil {
+		cancel()
+		return
+	}
+	// the timeout covers reading the body, so only release it once the caller
+	// is done with the response.
+	response.Body = cancelReadCloser{ReadCloser: response.Body, cancel: cancel}
 
 	// DumpResponse(response)
 	if err = ResponseAsError(response); err != nil {
@@ -146,6 +221,16 @@ func (u *URL) Request(method string, body io.Reader) (response *http.Response, e
 	return
 }
 
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelReadCloser) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
 func DumpRequest(req *http.Request) {
 	out, err := httputil.DumpRequestOut(req, true)
 	if err != nil {
@@ -204,3 +289,10 @@ type resErr struct {
 
 func (h resErr) Error() string            { return h.error }
 func (h resErr) Response() *http.Response { return h.response }
+
+// IsNotFound reports whether err is an HTTPResponseError for a 404, e.g. a
+// missing cache item or message.
+func IsNotFound(err error) bool {
+	e, ok := err.(HTTPResponseError)
+	return ok && e.Response().StatusCode == http.StatusNotFound
+}
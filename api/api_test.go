@@ -0,0 +1,126 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iron-io/iron_go/api"
+	"github.com/iron-io/iron_go/config"
+	. "github.com/jeffh/go.bdd"
+)
+
+// server answers every request with status, after waiting for delay or the
+// request to be canceled, and records when each request arrived.
+type server struct {
+	*httptest.Server
+	sync.Mutex
+	status int
+	delay  time.Duration
+	hits   []time.Time
+}
+
+func newServer() *server {
+	s := &server{status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Lock()
+		s.hits = append(s.hits, time.Now())
+		status, delay := s.status, s.delay
+		s.Unlock()
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`{"msg":"` + http.StatusText(status) + `"}`))
+	}))
+	return s
+}
+
+func (s *server) reset(status int, delay time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.status, s.delay, s.hits = status, delay, nil
+}
+
+func (s *server) arrivals() []time.Time {
+	s.Lock()
+	defer s.Unlock()
+	return append([]time.Time{}, s.hits...)
+}
+
+func (s *server) settings() config.Settings {
+	host, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return config.Settings{
+		Token: "token", ProjectId: "4f2a7c1b9e8d6f3a2b1c0d9e",
+		Host: host, Port: uint16(portNum), Scheme: "http", ApiVersion: "1",
+	}
+}
+
+func TestEverything(t *testing.T) {
+	defer PrintSpecReport()
+
+	s := newServer()
+	defer s.Close()
+
+	Describe("RequestContext", func() {
+		It("retries a 503 MaxRetries times", func() {
+			s.reset(http.StatusServiceUnavailable, 0)
+			settings := s.settings()
+			settings.MaxRetries = 2
+			settings.RetryBackoff = time.Millisecond
+			err := api.Action(settings, "things").Req("GET", nil, nil)
+			Expect(err, ToNotBeNil)
+			Expect(err.(api.HTTPResponseError).Response().StatusCode, ToEqual, http.StatusServiceUnavailable)
+			Expect(len(s.arrivals()), ToEqual, 3)
+		})
+
+		It("doesn't retry with negative MaxRetries", func() {
+			s.reset(http.StatusServiceUnavailable, 0)
+			settings := s.settings()
+			settings.MaxRetries = -1
+			Expect(api.Action(settings, "things").Req("GET", nil, nil), ToNotBeNil)
+			Expect(len(s.arrivals()), ToEqual, 1)
+		})
+
+		It("waits longer after each attempt, starting at RetryBackoff", func() {
+			s.reset(http.StatusServiceUnavailable, 0)
+			settings := s.settings()
+			settings.MaxRetries = 2
+			settings.RetryBackoff = 20 * time.Millisecond
+			api.Action(settings, "things").Req("GET", nil, nil)
+			hits := s.arrivals()
+			Expect(len(hits), ToEqual, 3)
+			Expect(hits[1].Sub(hits[0]) >= 20*time.Millisecond, ToEqual, true)
+			Expect(hits[2].Sub(hits[1]) >= 80*time.Millisecond, ToEqual, true)
+		})
+
+		It("gives up on requests taking longer than Timeout", func() {
+			s.reset(http.StatusOK, time.Second)
+			settings := s.settings()
+			settings.Timeout = 50 * time.Millisecond
+			start := time.Now()
+			err := api.Action(settings, "things").Req("GET", nil, nil)
+			Expect(errors.Is(err, context.DeadlineExceeded), ToEqual, true)
+			Expect(time.Since(start) < 500*time.Millisecond, ToEqual, true)
+		})
+
+		It("counts retries against Timeout", func() {
+			s.reset(http.StatusServiceUnavailable, 0)
+			settings := s.settings()
+			settings.Timeout = 50 * time.Millisecond
+			settings.RetryBackoff = time.Second
+			err := api.Action(settings, "things").Req("GET", nil, nil)
+			Expect(errors.Is(err, context.DeadlineExceeded), ToEqual, true)
+			Expect(len(s.arrivals()), ToEqual, 1)
+		})
+	})
+}
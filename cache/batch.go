@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/iron-io/iron_go/api"
+)
+
+// DefaultBatchConcurrency is how many requests GetMulti and PutMulti run at
+// once when the Cache's BatchConcurrency isn't set.
+var DefaultBatchConcurrency = 8
+
+// BatchResult is the outcome for one key of GetMulti or PutMulti.
+type BatchResult struct {
+	Key string
+	// Value and Found are only set by GetMulti. A missing key has Found
+	// false and no Err.
+	Value interface{}
+	Found bool
+	Err   error
+}
+
+// BatchError lists the keys of a batch that failed. The other keys
+// succeeded.
+type BatchError struct {
+	// Keys are the failed keys, in the order of the results.
+	Keys   []string
+	Errors map[string]error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("cache: %d keys failed: %s", len(e.Keys), strings.Join(e.Keys, ", "))
+}
+
+// GetMulti gets every key, returning one result per key in the same order.
+// Failed keys don't stop the others, they are listed in a *BatchError.
+func (c *Cache) GetMulti(ctx context.Context, keys []string) ([]BatchResult, error) {
+	results := make([]BatchResult, len(keys))
+	c.batch(len(keys), func(i int) {
+		value, err := c.GetContext(ctx, keys[i])
+		results[i] = BatchResult{Key: keys[i], Value: value, Found: err == nil}
+		if err != nil && !api.IsNotFound(err) {
+			results[i].Err = err
+		}
+	})
+	return results, batchError(results)
+}
+
+// PutMulti puts every item, returning one result per key sorted by key.
+// Failed keys don't stop the others, they are listed in a *BatchError.
+func (c *Cache) PutMulti(ctx context.Context, items map[string]*Item) ([]BatchResult, error) {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	results := make([]BatchResult, len(keys))
+	c.batch(len(keys), func(i int) {
+		results[i] = BatchResult{Key: keys[i], Err: c.PutContext(ctx, keys[i], items[keys[i]])}
+	})
+	return results, batchError(results)
+}
+
+// batch calls fn for 0..n-1 with at most BatchConcurrency calls at once.
+func (c *Cache) batch(n int, fn func(i int)) {
+	limit := c.BatchConcurrency
+	if limit <= 0 {
+		limit = DefaultBatchConcurrency
+	}
+	sem := make(chan struct{}, limit)
+	wg := sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func batchError(results []BatchResult) error {
+	var e *BatchError
+	for _, r := range results {
+		if r.Err == nil {
+			continue
+		}
+		if e == nil {
+			e = &BatchError{Errors: map[string]error{}}
+		}
+		e.Keys = append(e.Keys, r.Key)
+		e.Errors[r.Key] = r.Err
+	}
+	if e == nil {
+		return nil
+	}
+	return e
+}
@@ -3,9 +3,15 @@ package cache
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/iron-io/iron_go/api"
@@ -14,12 +20,27 @@ import (
 
 var (
 	JSON = Codec{Marshal: json.Marshal, Unmarshal: json.Unmarshal}
-	Gob  = Codec{Marshal: gobMarshal, Unmarshal: gobUnmarshal}
+	Gob  = Binary("gob", gobMarshal, gobUnmarshal)
 )
 
 type Cache struct {
 	Settings config.Settings
 	Name     string
+	// HttpClient is used for the cache's requests instead of api.HttpClient
+	// when set.
+	HttpClient *http.Client
+	// BatchConcurrency limits the requests GetMulti and PutMulti run at
+	// once, DefaultBatchConcurrency if zero.
+	BatchConcurrency int
+	// ChunkSize, when set, splits string values longer than it over several
+	// items that Get reassembles, for values over IronCache's item size limit.
+	// Lengths are measured as sent, after escaping for JSON.
+	// Writes then also read the previous value to delete its chunks. Every
+	// Cache reading such values needs it set too.
+	ChunkSize int
+
+	// namespace is put in front of every key, see Namespace.
+	namespace string
 }
 
 type Item struct {
@@ -28,7 +49,7 @@ type Item struct {
 	// Object is the Item's value for use with a Codec.
 	Object interface{}
 	// Number of seconds until expiration. The zero value defaults to 7 days,
-	// maximum is 30 days.
+	// maximum is 30 days. Fractions of a second are rounded up.
 	Expiration time.Duration
 	// Caches item only if the key is currently cached.
 	Replace bool
@@ -42,8 +63,62 @@ func New(cacheName string) *Cache {
 	return &Cache{Settings: config.Config("iron_cache"), Name: cacheName}
 }
 
+// Option configures a Cache created by NewCache.
+type Option func(*options)
+
+type options struct {
+	env      string
+	settings *config.Settings
+	client   *http.Client
+}
+
+// WithSettings merges the given settings over the ones found in iron.json
+// files and environment variables.
+func WithSettings(settings *config.Settings) Option {
+	return func(o *options) { o.settings = settings }
+}
+
+// WithClient makes requests using the given http.Client.
+func WithClient(client *http.Client) Option {
+	return func(o *options) { o.client = client }
+}
+
+// WithEnv picks the named environment out of iron.json files, see
+// config.ConfigWithEnv.
+func WithEnv(env string) Option {
+	return func(o *options) { o.env = env }
+}
+
+// NewCache returns a Cache for cacheName configured by the given options.
+// Unlike New, invalid configuration is returned as an error.
+func NewCache(cacheName string, opts ...Option) (*Cache, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	settings, err := config.Load("iron_cache", o.env, o.settings)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{Settings: settings, Name: cacheName, HttpClient: o.client}, nil
+}
+
 func (c *Cache) caches(suffix ...string) *api.URL {
-	return api.Action(c.Settings, "caches", suffix...)
+	u := api.Action(c.Settings, "caches", suffix...)
+	u.HttpClient = c.HttpClient
+	// names and keys may contain slashes, which must not split the path.
+	if len(suffix) > 0 {
+		escaped := make([]string, len(suffix))
+		for i, s := range suffix {
+			escaped[i] = url.PathEscape(s)
+		}
+		u.URL.RawPath = strings.TrimSuffix(u.URL.Path, strings.Join(suffix, "/")) + strings.Join(escaped, "/")
+	}
+	return u
+}
+
+func (c *Cache) item(key string, suffix ...string) *api.URL {
+	return c.caches(append([]string{c.Name, "items", c.StoredKey(key)}, suffix...)...)
 }
 
 func (c *Cache) ListCaches(page, perPage int) (caches []*Cache, err error) {
@@ -63,8 +138,11 @@ func (c *Cache) ListCaches(page, perPage int) (caches []*Cache, err error) {
 	caches = make([]*Cache, 0, len(out))
 	for _, item := range out {
 		caches = append(caches, &Cache{
-			Settings: c.Settings,
-			Name:     item.Name,
+			Settings:         c.Settings,
+			Name:             item.Name,
+			HttpClient:       c.HttpClient,
+			BatchConcurrency: c.BatchConcurrency,
+			ChunkSize:        c.ChunkSize,
 		})
 	}
 
@@ -73,7 +151,9 @@ func (c *Cache) ListCaches(page, perPage int) (caches []*Cache, err error) {
 
 func (c *Cache) ServerVersion() (version string, err error) {
 	out := map[string]string{}
-	err = api.VersionAction(c.Settings).Req("GET", nil, &out)
+	u := api.VersionAction(c.Settings)
+	u.HttpClient = c.HttpClient
+	err = u.Req("GET", nil, &out)
 	if err != nil {
 		return
 	}
@@ -81,11 +161,23 @@ func (c *Cache) ServerVersion() (version string, err error) {
 }
 
 func (c *Cache) Clear() (err error) {
-	return c.caches(c.Name, "clear").Req("POST", nil, nil)
+	return c.ClearContext(context.Background())
 }
 
 // Put adds an Item to the cache, overwriting any existing key of the same name.
 func (c *Cache) Put(key string, item *Item) (err error) {
+	return c.PutContext(context.Background(), key, item)
+}
+
+// PutContext is like Put, but gives up when ctx is done.
+func (c *Cache) PutContext(ctx context.Context, key string, item *Item) (err error) {
+	if c.ChunkSize > 0 {
+		return c.putChunked(ctx, key, item)
+	}
+	return c.putItem(ctx, key, item)
+}
+
+func (c *Cache) putItem(ctx context.Context, key string, item *Item) (err error) {
 	in := struct {
 		Value     interface{} `json:"value"`
 		ExpiresIn int         `json:"expires_in,omitempty"`
@@ -93,12 +185,25 @@ func (c *Cache) Put(key string, item *Item) (err error) {
 		Add       bool        `json:"add,omitempty"`
 	}{
 		Value:     item.Value,
-		ExpiresIn: int(item.Expiration.Seconds()),
+		ExpiresIn: expiresIn(item.Expiration),
 		Replace:   item.Replace,
 		Add:       item.Add,
 	}
 
-	return c.caches(c.Name, "items", key).Req("PUT", &in, nil)
+	if err = c.item(key).ReqContext(ctx, "PUT", &in, nil); err != nil {
+		return err
+	}
+	c.recordKey(ctx, key, item.Expiration)
+	return nil
+}
+
+// expiresIn converts an expiration to whole seconds, rounding up so a short
+// one doesn't become 0, which the server takes as its 7 day default.
+func expiresIn(expiration time.Duration) int {
+	if expiration <= 0 {
+		return 0
+	}
+	return int(math.Ceil(expiration.Seconds()))
 }
 
 func anyToString(value interface{}) (str interface{}, err error) {
@@ -123,81 +228,214 @@ func anyToString(value interface{}) (str interface{}, err error) {
 	return
 }
 
+// ItemOption tunes how a value is stored.
+type ItemOption func(*Item)
+
+// TTL sets how long the item is cached, see Item.Expiration.
+func TTL(d time.Duration) ItemOption {
+	return func(item *Item) { item.Expiration = d }
+}
+
+// IfAbsent only stores the item if the key isn't currently cached.
+func IfAbsent() ItemOption {
+	return func(item *Item) { item.Add = true }
+}
+
+// IfPresent only stores the item if the key is currently cached.
+func IfPresent() ItemOption {
+	return func(item *Item) { item.Replace = true }
+}
+
+// IsNotStored reports whether err is the server refusing an IfAbsent write
+// because the key exists, or an IfPresent one because it doesn't.
+func IsNotStored(err error) bool {
+	e, ok := err.(api.HTTPResponseError)
+	if !ok {
+		return false
+	}
+	switch e.Response().StatusCode {
+	case http.StatusNotFound, http.StatusConflict:
+		return true
+	}
+	return false
+}
+
+// SetContext stores value at key. Strings, numbers and booleans are stored
+// as they are, fmt.Stringers as their String() and anything else as JSON.
+//
+//	err := c.SetContext(ctx, "greeting", "hello", cache.TTL(time.Hour), cache.IfAbsent())
+func (c *Cache) SetContext(ctx context.Context, key string, value interface{}, opts ...ItemOption) (err error) {
+	item, err := newItem(value, opts)
+	if err != nil {
+		return err
+	}
+	return c.PutContext(ctx, key, item)
+}
+
+func newItem(value interface{}, opts []ItemOption) (item *Item, err error) {
+	item = &Item{}
+	for _, opt := range opts {
+		opt(item)
+	}
+	if item.Add && item.Replace {
+		return nil, errors.New("cache: IfAbsent and IfPresent can't be combined")
+	}
+	item.Value, err = anyToString(value)
+	return item, err
+}
+
+// Set stores value at key, for ttl[0] seconds if given.
+//
+// Deprecated: Use SetContext with the TTL option.
 func (c *Cache) Set(key string, value interface{}, ttl ...int) (err error) {
-	str, err := anyToString(value)
-	if err == nil {
-		if len(ttl) > 0 {
-			err = c.Put(key, &Item{Value: str, Expiration: time.Duration(ttl[0]) * time.Second})
-		} else {
-			err = c.Put(key, &Item{Value: str})
-		}
+	opts := []ItemOption{}
+	if len(ttl) > 0 {
+		opts = append(opts, TTL(time.Duration(ttl[0])*time.Second))
 	}
-	return
+	return c.SetContext(context.Background(), key, value, opts...)
 }
+
+// Add stores value at key only if the key isn't currently cached. Passing
+// more than one value stores them as a JSON array.
+//
+// Deprecated: Use SetContext with the IfAbsent option.
 func (c *Cache) Add(key string, value ...interface{}) (err error) {
-	str, err := anyToString(value)
-	if err == nil {
-		err = c.Put(key, &Item{
-			Value: str, Expiration: time.Duration(123) * time.Second, Add: true,
-		})
-	}
-	return
+	return c.SetContext(context.Background(), key, oneValue(value), IfAbsent())
 }
+
+// Replace stores value at key only if the key is currently cached. Passing
+// more than one value stores them as a JSON array.
+//
+// Deprecated: Use SetContext with the IfPresent option.
 func (c *Cache) Replace(key string, value ...interface{}) (err error) {
-	str, err := anyToString(value)
-	if err == nil {
-		err = c.Put(key, &Item{
-			Value: str, Expiration: time.Duration(123) * time.Second, Replace: true,
-		})
+	return c.SetContext(context.Background(), key, oneValue(value), IfPresent())
+}
+
+// oneValue unwraps the variadic value of Add and Replace.
+func oneValue(values []interface{}) interface{} {
+	if len(values) == 1 {
+		return values[0]
 	}
-	return
+	return values
 }
 
 // Increment increments the corresponding item's value.
 func (c *Cache) Increment(key string, amount int64) (value interface{}, err error) {
+	return c.IncrementContext(context.Background(), key, amount)
+}
+
+// IncrementContext is like Increment, but gives up when ctx is done.
+func (c *Cache) IncrementContext(ctx context.Context, key string, amount int64) (value interface{}, err error) {
 	in := map[string]int64{"amount": amount}
 
 	out := struct {
 		Message string      `json:"msg"`
 		Value   interface{} `json:"value"`
 	}{}
-	if err = c.caches(c.Name, "items", key, "increment").Req("POST", &in, &out); err == nil {
+	if err = c.item(key, "increment").ReqContext(ctx, "POST", &in, &out); err == nil {
 		value = out.Value
 	}
 	return
 }
 
+// incrementOrCreate increments key by amount, first creating it as initial
+// to expire after expiration if it's missing, and returns the new value.
+func (c *Cache) incrementOrCreate(ctx context.Context, key string, amount, initial int64, expiration time.Duration) (int64, error) {
+	value, err := c.IncrementContext(ctx, key, amount)
+	if api.IsNotFound(err) {
+		err = c.PutContext(ctx, key, &Item{Value: initial, Expiration: expiration, Add: true})
+		if err != nil && !IsNotStored(err) {
+			return 0, err
+		}
+		value, err = c.IncrementContext(ctx, key, amount)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return integer(key, value)
+}
+
+// integer converts the value of key, as decoded from JSON, to an int64.
+func integer(key string, value interface{}) (int64, error) {
+	n, ok := value.(float64)
+	if !ok || n != math.Trunc(n) {
+		return 0, fmt.Errorf("cache: %s isn't an integer", key)
+	}
+	return int64(n), nil
+}
+
 // Get gets an item from the cache.
 func (c *Cache) Get(key string) (value interface{}, err error) {
+	return c.GetContext(context.Background(), key)
+}
+
+// GetContext is like Get, but gives up when ctx is done. A missing key is
+// reported as an error satisfying api.IsNotFound.
+func (c *Cache) GetContext(ctx context.Context, key string) (value interface{}, err error) {
 	out := struct {
 		Cache string      `json:"cache"`
 		Key   string      `json:"key"`
 		Value interface{} `json:"value"`
 	}{}
-	if err = c.caches(c.Name, "items", key).Req("GET", nil, &out); err == nil {
-		value = out.Value
+	if err = c.item(key).ReqContext(ctx, "GET", nil, &out); err != nil {
+		return
 	}
-	return
+	return c.unchunk(ctx, key, out.Value)
 }
 
 func (c *Cache) GetMeta(key string) (value map[string]interface{}, err error) {
+	return c.GetMetaContext(context.Background(), key)
+}
+
+// GetMetaContext is like GetMeta, but gives up when ctx is done.
+func (c *Cache) GetMetaContext(ctx context.Context, key string) (value map[string]interface{}, err error) {
 	value = map[string]interface{}{}
-	err = c.caches(c.Name, "items", key).Req("GET", nil, &value)
+	if err = c.item(key).ReqContext(ctx, "GET", nil, &value); err != nil {
+		return
+	}
+	value["value"], err = c.unchunk(ctx, key, value["value"])
 	return
 }
 
 // Delete removes an item from the cache.
 func (c *Cache) Delete(key string) (err error) {
-	return c.caches(c.Name, "items", key).Req("DELETE", nil, nil)
+	return c.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext is like Delete, but gives up when ctx is done.
+func (c *Cache) DeleteContext(ctx context.Context, key string) (err error) {
+	if c.ChunkSize > 0 {
+		defer c.deleteChunks(ctx, key, c.manifest(ctx, key))
+	}
+	return c.deleteItem(ctx, key)
+}
+
+func (c *Cache) deleteItem(ctx context.Context, key string) (err error) {
+	if err = c.item(key).ReqContext(ctx, "DELETE", nil, nil); err != nil {
+		return err
+	}
+	c.forgetKey(ctx, key)
+	return nil
 }
 
 type Codec struct {
+	// Name identifies the codec in the header of values written by codecs
+	// created with Binary, see Detect. Empty for JSON.
+	Name      string
 	Marshal   func(interface{}) ([]byte, error)
 	Unmarshal func([]byte, interface{}) error
+
+	// the codec's bytes before Binary's text encoding
+	marshalRaw   func(interface{}) ([]byte, error)
+	unmarshalRaw func([]byte, interface{}) error
+	// used instead of Marshal and Unmarshal by codecs that depend on where
+	// the value is stored, see Encrypted
+	marshalFor   func(cacheName, key string, v interface{}) ([]byte, error)
+	unmarshalFor func(cacheName, key string, data []byte, v interface{}) error
 }
 
 func (cd Codec) Put(c *Cache, key string, item *Item) (err error) {
-	bytes, err := cd.Marshal(item.Object)
+	bytes, err := cd.encode(c, key, item.Object)
 	if err != nil {
 		return
 	}
@@ -213,14 +451,54 @@ func (cd Codec) Get(c *Cache, key string, object interface{}) (err error) {
 		return
 	}
 
-	err = cd.Unmarshal([]byte(value.(string)), object)
+	return cd.decode(c, key, value, object)
+}
+
+// encode marshals v for storing at key of c. Codecs depending on where the
+// value is stored get the stored key, so items of different namespaces of
+// one cache are told apart.
+func (cd Codec) encode(c *Cache, key string, v interface{}) ([]byte, error) {
+	if cd.marshalFor != nil {
+		return cd.marshalFor(c.Name, c.StoredKey(key), v)
+	}
+	return cd.Marshal(v)
+}
+
+// decode unmarshals the value read from key of c into object.
+func (cd Codec) decode(c *Cache, key string, value, object interface{}) (err error) {
+	str, ok := value.(string)
+	if !ok {
+		return &TypeMismatchError{Key: key, Value: value}
+	}
+
+	err = cd.unmarshalAt(c.Name, c.StoredKey(key), []byte(str), object)
+	if _, tampered := err.(*TamperError); tampered {
+		return err
+	}
 	if err != nil {
-		return
+		return &TypeMismatchError{Key: key, Value: value, Err: err}
 	}
+	return nil
+}
 
-	return
+// TypeMismatchError is returned when a cached value can't be decoded into
+// the requested type, e.g. a number stored by Set read back through a Codec.
+type TypeMismatchError struct {
+	Key   string
+	Value interface{}
+	// Err is the Codec's error, nil if the value wasn't even a string.
+	Err error
+}
+
+func (e *TypeMismatchError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("cache: can't decode %q: %v", e.Key, e.Err)
+	}
+	return fmt.Sprintf("cache: %q holds a %T, not an encoded value", e.Key, e.Value)
 }
 
+func (e *TypeMismatchError) Unwrap() error { return e.Err }
+
 func gobMarshal(v interface{}) ([]byte, error) {
 	writer := bytes.Buffer{}
 	enc := gob.NewEncoder(&writer)
@@ -1,7 +1,10 @@
 package cache_test
 
 import (
+	"context"
 	"fmt"
+	"time"
+
 	"github.com/iron-io/iron_go/cache"
 )
 
@@ -10,15 +13,16 @@ func p(a ...interface{}) { fmt.Println(a...) }
 func Example1StoringData() {
 	// For configuration info, see http://dev.iron.io/articles/configuration
 	c := cache.New("test_cache")
+	ctx := context.Background()
 
 	// Numbers will get stored as numbers
-	c.Set("number_item", 42)
+	c.SetContext(ctx, "number_item", 42)
 
-	// Strings get stored as strings
-	c.Set("string_item", "Hello, IronCache")
+	// Strings get stored as strings, here for an hour
+	c.SetContext(ctx, "string_item", "Hello, IronCache", cache.TTL(time.Hour))
 
 	// Objects and dicts get JSON-encoded and stored as strings
-	c.Set("complex_item", map[string]interface{}{
+	c.SetContext(ctx, "complex_item", map[string]interface{}{
 		"test": "this is a dict",
 		"args": []string{"apples", "oranges"},
 	})
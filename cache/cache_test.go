@@ -1,15 +1,36 @@
 package cache_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 
+	"github.com/iron-io/iron_go/api"
 	"github.com/iron-io/iron_go/cache"
 	. "github.com/jeffh/go.bdd"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 func TestEverything(t *testing.T) {}
 
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
 func init() {
 	defer PrintSpecReport()
 
@@ -55,4 +76,951 @@ func init() {
 			Expect(value.(float64), ToEqual, 42.0)
 		})
 	})
+
+	Describe("SetContext", func() {
+		server := newFakeServer()
+		c := server.cache("set")
+		ctx := context.Background()
+
+		It("stores values the same way whatever the condition", func() {
+			Expect(c.SetContext(ctx, "a", 42, cache.TTL(time.Minute)), ToBeNil)
+			Expect(c.SetContext(ctx, "a", 43, cache.IfAbsent()), ToNotBeNil)
+			Expect(c.SetContext(ctx, "a", 44, cache.IfPresent()), ToBeNil)
+			Expect(c.SetContext(ctx, "b", 1, cache.IfPresent()), ToNotBeNil)
+			Expect(c.SetContext(ctx, "b", 1, cache.IfPresent(), cache.IfAbsent()), ToNotBeNil)
+			value, _ := c.Get("a")
+			Expect(value, ToEqual, 44.0)
+		})
+
+		It("keeps Add and Replace working", func() {
+			Expect(c.Add("single", "value"), ToBeNil)
+			value, _ := c.Get("single")
+			Expect(value, ToEqual, "value")
+
+			meta, _ := c.GetMeta("single")
+			Expect(meta["expires"], ToEqual, "9999-01-01T00:00:00Z")
+
+			Expect(c.Replace("single", map[string]int{"n": 1}), ToBeNil)
+			value, _ = c.Get("single")
+			Expect(value, ToEqual, `{"n":1}`)
+		})
+	})
+
+	Describe("Typed", func() {
+		server := newFakeServer()
+		c := server.cache("typed")
+		ctx := context.Background()
+
+		type user struct {
+			Name string
+			Age  int
+		}
+		users := cache.NewTyped[user](c, cache.JSON)
+
+		It("distinguishes misses from errors", func() {
+			_, found, err := users.Get(ctx, "nobody")
+			Expect(err, ToBeNil)
+			Expect(found, ToEqual, false)
+		})
+
+		It("round trips values", func() {
+			Expect(users.Set(ctx, "ann", user{Name: "Ann", Age: 42}, cache.TTL(time.Minute)), ToBeNil)
+			u, found, err := users.Get(ctx, "ann")
+			Expect(err, ToBeNil)
+			Expect(found, ToEqual, true)
+			Expect(u, ToEqual, user{Name: "Ann", Age: 42})
+		})
+
+		It("adds and replaces", func() {
+			Expect(users.Add(ctx, "ann", user{Name: "Other"}), ToNotBeNil)
+			Expect(users.Replace(ctx, "bob", user{Name: "Bob"}), ToNotBeNil)
+			Expect(users.Replace(ctx, "ann", user{Name: "Ann", Age: 43}), ToBeNil)
+			u, _, _ := users.Get(ctx, "ann")
+			Expect(u.Age, ToEqual, 43)
+		})
+
+		It("reports type mismatches", func() {
+			Expect(c.Set("number", 42), ToBeNil)
+			_, _, err := users.Get(ctx, "number")
+			_, ok := err.(*cache.TypeMismatchError)
+			Expect(ok, ToEqual, true)
+
+			counts := cache.NewTyped[int](c, cache.Gob)
+			Expect(counts.Set(ctx, "count", 7), ToBeNil)
+			n, _, err := counts.Get(ctx, "count")
+			Expect(err, ToBeNil)
+			Expect(n, ToEqual, 7)
+		})
+	})
+
+	Describe("NearCache", func() {
+		server := newFakeServer()
+		near := cache.NewNearCache(server.cache("near"), 2, time.Minute)
+
+		It("serves repeated reads from memory", func() {
+			Expect(near.Set("a", 1), ToBeNil)
+			before := server.requests
+			value, err := near.Get("a")
+			Expect(err, ToBeNil)
+			Expect(value, ToEqual, 1.0)
+			Expect(server.requests, ToEqual, before)
+			Expect(near.Stats().Hits, ToEqual, int64(1))
+		})
+
+		It("updates on increment and forgets on delete", func() {
+			_, err := near.Increment("a", 2)
+			Expect(err, ToBeNil)
+			value, _ := near.Get("a")
+			Expect(value, ToEqual, 3.0)
+
+			Expect(near.Delete("a"), ToBeNil)
+			_, err = near.Get("a")
+			Expect(err, ToNotBeNil)
+		})
+
+		It("evicts the least recently used items", func() {
+			near.Set("x", "x")
+			near.Set("y", "y")
+			near.Get("x")
+			near.Set("z", "z")
+			Expect(near.Len(), ToEqual, 2)
+			Expect(near.Stats().Evictions, ToEqual, int64(1))
+
+			before := server.requests
+			near.Get("y")
+			Expect(server.requests, ToEqual, before+1)
+		})
+
+		It("never outlives the item's expiration", func() {
+			Expect(near.Put("short", &cache.Item{Value: "v", Expiration: time.Second}), ToBeNil)
+			time.Sleep(1100 * time.Millisecond)
+			_, err := near.Get("short")
+			Expect(err, ToNotBeNil)
+			Expect(near.Stats().Expirations, ToEqual, int64(1))
+		})
+
+		It("works as a literal", func() {
+			literal := &cache.NearCache{Cache: server.cache("near"), Size: 2, TTL: time.Minute}
+			Expect(literal.Len(), ToEqual, 0)
+			Expect(literal.Set("lit", 1), ToBeNil)
+			Expect(literal.Len(), ToEqual, 1)
+			literal.Purge()
+			Expect(literal.Len(), ToEqual, 0)
+		})
+	})
+
+	Describe("Lock", func() {
+		server := newFakeServer()
+		c := server.cache("locks")
+		ctx := context.Background()
+
+		It("excludes other owners and hands out increasing fences", func() {
+			a := cache.NewLock(c, "job", 3*time.Second)
+			b := cache.NewLock(c, "job", 3*time.Second)
+
+			ok, err := a.TryLock(ctx)
+			Expect(err, ToBeNil)
+			Expect(ok, ToEqual, true)
+			ok, err = b.TryLock(ctx)
+			Expect(err, ToBeNil)
+			Expect(ok, ToEqual, false)
+			Expect(b.Unlock(ctx), ToEqual, cache.ErrLockNotHeld)
+
+			done := make(chan error)
+			go func() { done <- b.Lock(ctx) }()
+			time.Sleep(100 * time.Millisecond)
+			Expect(a.Unlock(ctx), ToBeNil)
+			Expect(<-done, ToBeNil)
+			Expect(b.Fence() > a.Fence(), ToEqual, true)
+			Expect(b.Unlock(ctx), ToBeNil)
+		})
+
+		It("extends the lease while held", func() {
+			a := cache.NewLock(c, "long", time.Second)
+			ok, _ := a.TryLock(ctx)
+			Expect(ok, ToEqual, true)
+			time.Sleep(2500 * time.Millisecond)
+
+			ok, _ = cache.NewLock(c, "long", time.Second).TryLock(ctx)
+			Expect(ok, ToEqual, false)
+			Expect(a.Unlock(ctx), ToBeNil)
+		})
+
+		It("gives up when the context is done", func() {
+			a := cache.NewLock(c, "busy", 3*time.Second)
+			a.TryLock(ctx)
+			defer a.Unlock(ctx)
+
+			short, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+			defer cancel()
+			Expect(cache.NewLock(c, "busy", 3*time.Second).Lock(short), ToEqual, context.DeadlineExceeded)
+		})
+
+		It("fails right away on bad requests", func() {
+			short, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			err := cache.NewLock(c, "forever", 31*24*time.Hour).Lock(short)
+			Expect(err, ToNotBeNil)
+			Expect(err == context.DeadlineExceeded, ToEqual, false)
+		})
+
+		It("keeps fences increasing after the counter expired", func() {
+			a := cache.NewLock(c, "fenced", 3*time.Second)
+			ok, _ := a.TryLock(ctx)
+			Expect(ok, ToEqual, true)
+			Expect(a.Fence() >= time.Now().Add(-time.Minute).UnixMilli(), ToEqual, true)
+			Expect(a.Unlock(ctx), ToBeNil)
+			Expect(c.Delete("lock:fenced:fence"), ToBeNil)
+
+			time.Sleep(10 * time.Millisecond)
+			b := cache.NewLock(c, "fenced", 3*time.Second)
+			ok, _ = b.TryLock(ctx)
+			Expect(ok, ToEqual, true)
+			Expect(b.Fence() > a.Fence(), ToEqual, true)
+			Expect(b.Unlock(ctx), ToBeNil)
+		})
+	})
+
+	Describe("Loader", func() {
+		server := newFakeServer()
+		c := server.cache("loader")
+		ctx := context.Background()
+
+		var mu sync.Mutex
+		loads := 0
+		load := func(ctx context.Context, key string) (string, error) {
+			mu.Lock()
+			loads++
+			mu.Unlock()
+			time.Sleep(200 * time.Millisecond)
+			if key == "broken" {
+				return "", errors.New("broken")
+			}
+			return "computed " + key, nil
+		}
+
+		It("coalesces concurrent misses and caches the result", func() {
+			loader := cache.NewLoader(cache.NewTyped[string](c, cache.JSON), time.Minute, load)
+			wg := sync.WaitGroup{}
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					value, err := loader.Get(ctx, "a")
+					Expect(err, ToBeNil)
+					Expect(value, ToEqual, "computed a")
+				}()
+			}
+			wg.Wait()
+			value, _ := loader.Get(ctx, "a")
+			Expect(value, ToEqual, "computed a")
+			Expect(loads, ToEqual, 1)
+		})
+
+		It("doesn't cache errors", func() {
+			loader := cache.NewLoader(cache.NewTyped[string](c, cache.JSON), time.Minute, load)
+			_, err := loader.Get(ctx, "broken")
+			Expect(err, ToNotBeNil)
+			_, err = c.Get("broken")
+			Expect(api.IsNotFound(err), ToEqual, true)
+		})
+
+		It("guards loads across processes", func() {
+			loads = 0
+			a := cache.NewLoader(cache.NewTyped[string](c, cache.JSON), time.Minute, load)
+			b := cache.NewLoader(cache.NewTyped[string](c, cache.JSON), time.Minute, load)
+			a.Guard, b.Guard = 5*time.Second, 5*time.Second
+
+			done := make(chan string)
+			go func() { v, _ := a.Get(ctx, "b"); done <- v }()
+			go func() { v, _ := b.Get(ctx, "b"); done <- v }()
+			Expect(<-done, ToEqual, "computed b")
+			Expect(<-done, ToEqual, "computed b")
+			Expect(loads, ToEqual, 1)
+		})
+
+		It("serves stale values while refreshing them", func() {
+			loads = 0
+			loader := cache.NewLoader(cache.NewTyped[string](c, cache.JSON), time.Minute, load)
+			loader.SoftTTL = 300 * time.Millisecond
+
+			_, status, err := loader.GetWithStatus(ctx, "s")
+			Expect(err, ToBeNil)
+			Expect(status, ToEqual, cache.Loaded)
+			_, status, _ = loader.GetWithStatus(ctx, "s")
+			Expect(status, ToEqual, cache.Fresh)
+
+			time.Sleep(400 * time.Millisecond)
+			start := time.Now()
+			value, status, err := loader.GetWithStatus(ctx, "s")
+			Expect(err, ToBeNil)
+			Expect(status, ToEqual, cache.Stale)
+			Expect(value, ToEqual, "computed s")
+			Expect(time.Since(start) < 200*time.Millisecond, ToEqual, true)
+			loader.GetWithStatus(ctx, "s")
+
+			time.Sleep(300 * time.Millisecond)
+			_, status, _ = loader.GetWithStatus(ctx, "s")
+			Expect(status, ToEqual, cache.Fresh)
+			Expect(loads, ToEqual, 2)
+		})
+
+		It("reloads values written without SoftTTL", func() {
+			plain := cache.NewTyped[string](c, cache.JSON)
+			Expect(plain.Set(ctx, "plain", "written before"), ToBeNil)
+			loader := cache.NewLoader(plain, time.Minute, load)
+			loader.SoftTTL = time.Second
+			value, status, err := loader.GetWithStatus(ctx, "plain")
+			Expect(err, ToBeNil)
+			Expect(status, ToEqual, cache.Loaded)
+			Expect(value, ToEqual, "computed plain")
+
+			type point struct{ X, Y int }
+			Expect(cache.NewTyped[point](c, cache.JSON).Set(ctx, "point", point{1, 2}), ToBeNil)
+			points := cache.NewLoader(cache.NewTyped[point](c, cache.JSON), time.Minute,
+				func(ctx context.Context, key string) (point, error) { return point{3, 4}, nil })
+			points.SoftTTL = time.Second
+			p, status, err := points.GetWithStatus(ctx, "point")
+			Expect(err, ToBeNil)
+			Expect(status, ToEqual, cache.Loaded)
+			Expect(p, ToEqual, point{3, 4})
+		})
+
+		It("requires SoftTTL to be shorter than TTL", func() {
+			loader := cache.NewLoader(cache.NewTyped[string](c, cache.JSON), time.Minute, load)
+			loader.SoftTTL = time.Minute
+			_, err := loader.Get(ctx, "never")
+			Expect(err, ToEqual, cache.ErrSoftTTL)
+		})
+
+		It("doesn't let sub-second guards last for the default week", func() {
+			Expect(c.Put("blink", &cache.Item{Value: 1, Expiration: 300 * time.Millisecond}), ToBeNil)
+			time.Sleep(1100 * time.Millisecond)
+			_, err := c.Get("blink")
+			Expect(api.IsNotFound(err), ToEqual, true)
+		})
+	})
+
+	Describe("GetMulti and PutMulti", func() {
+		server := newFakeServer()
+		c := server.cache("batch")
+		c.BatchConcurrency = 2
+		ctx := context.Background()
+
+		It("puts and gets many keys in order", func() {
+			results, err := c.PutMulti(ctx, map[string]*cache.Item{
+				"b": {Value: "2"},
+				"a": {Value: "1"},
+				"c": {Value: "3"},
+			})
+			Expect(err, ToBeNil)
+			Expect(results[0].Key, ToEqual, "a")
+			Expect(results[2].Key, ToEqual, "c")
+
+			results, err = c.GetMulti(ctx, []string{"c", "missing", "a"})
+			Expect(err, ToBeNil)
+			Expect(results[0].Value, ToEqual, "3")
+			Expect(results[1].Found, ToEqual, false)
+			Expect(results[2].Value, ToEqual, "1")
+		})
+
+		It("reports failed keys without aborting the batch", func() {
+			results, err := c.PutMulti(ctx, map[string]*cache.Item{
+				"a": {Value: "again", Add: true},
+				"d": {Value: "4", Add: true},
+			})
+			batchErr, ok := err.(*cache.BatchError)
+			Expect(ok, ToEqual, true)
+			Expect(batchErr.Keys, ToDeepEqual, []string{"a"})
+			Expect(results[1].Err, ToBeNil)
+		})
+	})
+
+	Describe("Codecs", func() {
+		server := newFakeServer()
+		c := server.cache("codecs")
+		ctx := context.Background()
+
+		type point struct{ X, Y int }
+		codecs := []cache.Codec{
+			cache.JSON,
+			cache.Gob,
+			cache.MsgPack,
+			cache.Compressed(cache.JSON, cache.Gzip),
+			cache.Compressed(cache.MsgPack, cache.Zstd),
+		}
+
+		It("round trip values through the cache", func() {
+			for _, codec := range codecs {
+				points := cache.NewTyped[point](c, codec)
+				Expect(points.Set(ctx, "p", point{1, 2}), ToBeNil)
+				p, found, err := points.Get(ctx, "p")
+				Expect(err, ToBeNil)
+				Expect(found, ToEqual, true)
+				Expect(p, ToEqual, point{1, 2})
+			}
+		})
+
+		It("encode protocol buffers", func() {
+			messages := cache.NewTyped[*wrapperspb.StringValue](c, cache.Proto)
+			Expect(messages.Set(ctx, "m", wrapperspb.String("hello")), ToBeNil)
+			m, _, err := messages.Get(ctx, "m")
+			Expect(err, ToBeNil)
+			Expect(m.GetValue(), ToEqual, "hello")
+		})
+
+		It("detect the codec a value was written with", func() {
+			for _, codec := range codecs {
+				data, err := codec.Marshal(point{3, 4})
+				Expect(err, ToBeNil)
+				p := point{}
+				Expect(cache.Detect(cache.JSON).Unmarshal(data, &p), ToBeNil)
+				Expect(p, ToEqual, point{3, 4})
+			}
+		})
+
+		It("refuse values decompressing past MaxDecompressedSize", func() {
+			buf := &bytes.Buffer{}
+			w := gzip.NewWriter(buf)
+			w.Write(make([]byte, cache.MaxDecompressedSize+1))
+			w.Close()
+			bomb := "#gzip+json:" + base64.StdEncoding.EncodeToString(buf.Bytes())
+			var v interface{}
+			Expect(cache.Compressed(cache.JSON, cache.Gzip).Unmarshal([]byte(bomb), &v), ToEqual, cache.ErrDecompressedTooLarge)
+		})
+	})
+
+	Describe("Encrypted", func() {
+		server := newFakeServer()
+		c := server.cache("secrets")
+		ctx := context.Background()
+
+		keyring, _ := cache.NewKeyring("k1", []byte("0123456789abcdef0123456789abcdef"))
+		tokens := cache.NewTyped[string](c, cache.Encrypted(cache.JSON, keyring))
+
+		It("round trips values without storing the plaintext", func() {
+			Expect(tokens.Set(ctx, "token", "hunter2"), ToBeNil)
+			raw, err := c.Get("token")
+			Expect(err, ToBeNil)
+			Expect(strings.Contains(raw.(string), "hunter2"), ToEqual, false)
+
+			token, found, err := tokens.Get(ctx, "token")
+			Expect(err, ToBeNil)
+			Expect(found, ToEqual, true)
+			Expect(token, ToEqual, "hunter2")
+		})
+
+		It("opens values sealed with a rotated key", func() {
+			Expect(tokens.Set(ctx, "old", "before"), ToBeNil)
+			Expect(keyring.Rotate("k2", []byte("fedcba9876543210")), ToBeNil)
+			Expect(tokens.Set(ctx, "new", "after"), ToBeNil)
+
+			old, _, err := tokens.Get(ctx, "old")
+			Expect(err, ToBeNil)
+			Expect(old, ToEqual, "before")
+			fresh, _, err := tokens.Get(ctx, "new")
+			Expect(err, ToBeNil)
+			Expect(fresh, ToEqual, "after")
+		})
+
+		It("refuses values copied from another key", func() {
+			Expect(tokens.Set(ctx, "alice", "alice's token"), ToBeNil)
+			raw, _ := c.Get("alice")
+			Expect(c.Put("mallory", &cache.Item{Value: raw}), ToBeNil)
+
+			_, found, err := tokens.Get(ctx, "mallory")
+			Expect(found, ToEqual, false)
+			tamper, ok := err.(*cache.TamperError)
+			Expect(ok, ToEqual, true)
+			Expect(tamper.Key, ToEqual, "mallory")
+		})
+
+		It("keeps binding values to their key when wrapped", func() {
+			for _, codec := range []cache.Codec{
+				cache.Detect(cache.Encrypted(cache.JSON, keyring)),
+				cache.Compressed(cache.Encrypted(cache.JSON, keyring), cache.Gzip),
+				cache.Detect(cache.Compressed(cache.Encrypted(cache.JSON, keyring), cache.Zstd)),
+			} {
+				wrapped := cache.NewTyped[string](c, codec)
+				Expect(wrapped.Set(ctx, "wrapped", "secret"), ToBeNil)
+				value, _, err := wrapped.Get(ctx, "wrapped")
+				Expect(err, ToBeNil)
+				Expect(value, ToEqual, "secret")
+
+				raw, _ := c.Get("wrapped")
+				Expect(c.Put("copied", &cache.Item{Value: raw}), ToBeNil)
+				_, _, err = wrapped.Get(ctx, "copied")
+				_, tampered := err.(*cache.TamperError)
+				Expect(tampered, ToEqual, true)
+			}
+		})
+
+		It("refuses values copied from another namespace", func() {
+			tenantA := cache.Namespace(c, "tenantA")
+			tenantB := cache.Namespace(c, "tenantB")
+			a := cache.NewTyped[string](tenantA, cache.Encrypted(cache.JSON, keyring))
+			b := cache.NewTyped[string](tenantB, cache.Encrypted(cache.JSON, keyring))
+			Expect(a.Set(ctx, "x", "tenant A's secret"), ToBeNil)
+
+			raw, _ := c.Get(tenantA.StoredKey("x"))
+			Expect(c.Put(tenantB.StoredKey("x"), &cache.Item{Value: raw}), ToBeNil)
+			_, found, err := b.Get(ctx, "x")
+			Expect(found, ToEqual, false)
+			_, tampered := err.(*cache.TamperError)
+			Expect(tampered, ToEqual, true)
+		})
+	})
+
+	Describe("Chunking", func() {
+		server := newFakeServer()
+		c := server.cache("chunks")
+		c.ChunkSize = 10
+		ctx := context.Background()
+		big := strings.Repeat("0123456789", 4) + "héllo"
+
+		It("splits long values and reassembles them", func() {
+			Expect(c.SetContext(ctx, "big", big, cache.TTL(time.Hour)), ToBeNil)
+			Expect(len(server.keys("chunks")), ToEqual, 6)
+			value, err := c.Get("big")
+			Expect(err, ToBeNil)
+			Expect(value, ToEqual, big)
+		})
+
+		It("keeps chunks within ChunkSize once escaped for JSON", func() {
+			escaped := server.cache("escaped")
+			escaped.ChunkSize = 10
+			html := strings.Repeat("<a>&\n", 5)
+			Expect(escaped.SetContext(ctx, "html", html), ToBeNil)
+
+			raw := server.cache("escaped")
+			for _, key := range server.keys("escaped") {
+				if !strings.Contains(key, ":chunk:") {
+					continue
+				}
+				value, _ := raw.Get(key)
+				data, _ := json.Marshal(value)
+				Expect(len(data)-2 <= 10, ToEqual, true)
+			}
+			value, err := escaped.Get("html")
+			Expect(err, ToBeNil)
+			Expect(value, ToEqual, html)
+		})
+
+		It("stores short values as they are", func() {
+			Expect(c.SetContext(ctx, "small", "tiny"), ToBeNil)
+			value, err := c.Get("small")
+			Expect(err, ToBeNil)
+			Expect(value, ToEqual, "tiny")
+		})
+
+		It("deletes the chunks of replaced values", func() {
+			Expect(c.SetContext(ctx, "big", "now small"), ToBeNil)
+			Expect(server.keys("chunks"), ToDeepEqual, []string{"big", "small"})
+		})
+
+		It("reads a missing chunk as a miss", func() {
+			Expect(c.SetContext(ctx, "big", big), ToBeNil)
+			for _, key := range server.keys("chunks") {
+				if strings.HasSuffix(key, ":2") {
+					Expect(server.cache("chunks").Delete(key), ToBeNil)
+				}
+			}
+			_, err := c.Get("big")
+			Expect(api.IsNotFound(err), ToEqual, true)
+		})
+
+		It("verifies the checksums", func() {
+			Expect(c.SetContext(ctx, "big", big), ToBeNil)
+			plain := server.cache("chunks")
+			for _, key := range server.keys("chunks") {
+				if strings.HasSuffix(key, ":0") {
+					Expect(plain.Put(key, &cache.Item{Value: "9876543210"}), ToBeNil)
+				}
+			}
+			_, err := c.Get("big")
+			Expect(errors.Is(err, cache.ErrChunkChecksum), ToEqual, true)
+		})
+	})
+
+	Describe("RateLimiter", func() {
+		server := newFakeServer()
+		c := server.cache("limits")
+		ctx := context.Background()
+
+		It("allows Limit requests per fixed window", func() {
+			limiter := cache.NewRateLimiter(c, 3, time.Hour, cache.FixedWindow)
+			for i := int64(2); i >= 0; i-- {
+				limit, err := limiter.Allow(ctx, "fixed")
+				Expect(err, ToBeNil)
+				Expect(limit.Allowed, ToEqual, true)
+				Expect(limit.Remaining, ToEqual, i)
+			}
+			limit, err := limiter.Allow(ctx, "fixed")
+			Expect(err, ToBeNil)
+			Expect(limit.Allowed, ToEqual, false)
+			Expect(limit.Reset, ToEqual, time.Now().Truncate(time.Hour).Add(time.Hour))
+		})
+
+		It("counts the previous window when sliding", func() {
+			limiter := cache.NewRateLimiter(c, 10, time.Hour, cache.SlidingWindow)
+			previous := time.Now().Truncate(time.Hour).Add(-time.Hour).Unix()
+			Expect(c.Put(fmt.Sprintf("ratelimit:sliding:%d", previous), &cache.Item{Value: 1000000}), ToBeNil)
+			limit, err := limiter.Allow(ctx, "sliding")
+			Expect(err, ToBeNil)
+			Expect(limit.Allowed, ToEqual, false)
+		})
+
+		It("sets RateLimit headers", func() {
+			limiter := cache.NewRateLimiter(c, 1, time.Hour, cache.FixedWindow)
+			h := limiter.Middleware(func(r *http.Request) string { return "http" })(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			Expect(w.Code, ToEqual, http.StatusOK)
+			Expect(w.Header().Get("RateLimit-Limit"), ToEqual, "1")
+			Expect(w.Header().Get("RateLimit-Remaining"), ToEqual, "0")
+
+			w = httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			Expect(w.Code, ToEqual, http.StatusTooManyRequests)
+			Expect(w.Header().Get("Retry-After") != "", ToEqual, true)
+		})
+	})
+
+	Describe("Cache management", func() {
+		server := newFakeServer()
+		c := server.cache("managed")
+		ctx := context.Background()
+
+		It("returns typed item metadata", func() {
+			Expect(c.SetContext(ctx, "k", "v", cache.TTL(time.Hour)), ToBeNil)
+			meta, err := c.GetItemMeta(ctx, "k")
+			Expect(err, ToBeNil)
+			Expect(meta.Key, ToEqual, "k")
+			Expect(meta.Value, ToEqual, "v")
+			Expect(time.Until(meta.Expires) > 59*time.Minute, ToEqual, true)
+		})
+
+		It("describes and lists caches", func() {
+			info, err := c.Info(ctx)
+			Expect(err, ToBeNil)
+			Expect(info.Name, ToEqual, "managed")
+			Expect(info.Size, ToEqual, 1)
+
+			caches, err := c.ListCacheInfo(ctx, 0, 100)
+			Expect(err, ToBeNil)
+			Expect(caches, ToDeepEqual, []cache.CacheInfo{{ProjectId: "4f2a7c1b9e8d6f3a2b1c0d9e", Name: "managed"}})
+		})
+
+		It("destroys caches", func() {
+			Expect(c.Destroy(ctx), ToBeNil)
+			caches, err := c.ListCacheInfo(ctx, 0, 100)
+			Expect(err, ToBeNil)
+			Expect(len(caches), ToEqual, 0)
+		})
+	})
+
+	Describe("Counter", func() {
+		server := newFakeServer()
+		c := server.cache("counters")
+		ctx := context.Background()
+
+		It("reads a missing counter as 0", func() {
+			n, err := cache.NewCounter(c, "missing").Get(ctx)
+			Expect(err, ToBeNil)
+			Expect(n, ToEqual, int64(0))
+		})
+
+		It("fails to add to a missing counter unless Init is set", func() {
+			counter := cache.NewCounter(c, "hits")
+			_, err := counter.Add(ctx, 1)
+			Expect(api.IsNotFound(err), ToEqual, true)
+
+			counter.Init = time.Hour
+			n, err := counter.Add(ctx, 5)
+			Expect(err, ToBeNil)
+			Expect(n, ToEqual, int64(5))
+			n, err = counter.Decr(ctx, 2)
+			Expect(err, ToBeNil)
+			Expect(n, ToEqual, int64(3))
+		})
+
+		It("sets and resets", func() {
+			counter := cache.NewCounter(c, "set")
+			Expect(counter.SetWithTTL(ctx, 41, time.Minute), ToBeNil)
+			n, err := counter.Add(ctx, 1)
+			Expect(err, ToBeNil)
+			Expect(n, ToEqual, int64(42))
+			Expect(counter.Reset(ctx), ToBeNil)
+			n, err = counter.Get(ctx)
+			Expect(err, ToBeNil)
+			Expect(n, ToEqual, int64(0))
+		})
+	})
+
+	Describe("Namespace", func() {
+		server := newFakeServer()
+		c := server.cache("shared")
+		ctx := context.Background()
+
+		It("keeps namespaces apart", func() {
+			Expect(cache.Namespace(c, "a").SetContext(ctx, "b:c", "1"), ToBeNil)
+			Expect(cache.Namespace(c, "a:b").SetContext(ctx, "c", "2"), ToBeNil)
+			Expect(server.keys("shared"), ToDeepEqual, []string{"a%3Ab:c", "a:b%3Ac"})
+
+			value, err := cache.Namespace(c, "a").Get("b:c")
+			Expect(err, ToBeNil)
+			Expect(value, ToEqual, "1")
+		})
+
+		It("nests", func() {
+			inner := cache.Namespace(cache.Namespace(c, "outer"), "inner")
+			Expect(inner.StoredKey("k"), ToEqual, "outer:inner:k")
+		})
+
+		It("escapes keys in the path", func() {
+			Expect(c.SetContext(ctx, "a/b?c", "slashed"), ToBeNil)
+			value, err := c.Get("a/b?c")
+			Expect(err, ToBeNil)
+			Expect(value, ToEqual, "slashed")
+		})
+
+		It("hashes long keys and records the original", func() {
+			ns := cache.Namespace(c, "long")
+			key := strings.Repeat("k", 300)
+			stored := ns.StoredKey(key)
+			Expect(len(stored) <= cache.MaxKeyLength, ToEqual, true)
+			Expect(ns.StoredKey(key), ToEqual, stored)
+
+			Expect(ns.SetContext(ctx, key, "v"), ToBeNil)
+			value, err := ns.Get(key)
+			Expect(err, ToBeNil)
+			Expect(value, ToEqual, "v")
+			original, err := ns.OriginalKey(ctx, stored)
+			Expect(err, ToBeNil)
+			Expect(original, ToEqual, key)
+
+			original, err = ns.OriginalKey(ctx, ns.StoredKey("a b"))
+			Expect(err, ToBeNil)
+			Expect(original, ToEqual, "a b")
+		})
+
+		It("hashes long keys outside of namespaces too", func() {
+			key := strings.Repeat("é", 200)
+			stored := c.StoredKey(key)
+			Expect(len(stored) <= cache.MaxKeyLength, ToEqual, true)
+			Expect(utf8.ValidString(stored), ToEqual, true)
+			Expect(c.StoredKey("short %41"), ToEqual, "short %41")
+
+			Expect(c.SetContext(ctx, key, "v"), ToBeNil)
+			value, err := c.Get(key)
+			Expect(err, ToBeNil)
+			Expect(value, ToEqual, "v")
+			original, err := c.OriginalKey(ctx, stored)
+			Expect(err, ToBeNil)
+			Expect(original, ToEqual, key)
+		})
+	})
+
+	Describe("Tags", func() {
+		server := newFakeServer()
+		c := server.cache("tagged")
+		ctx := context.Background()
+		tags := cache.NewTags(c)
+
+		It("embeds tag versions in keys", func() {
+			key, err := tags.Key(ctx, "report", "tenant:1", "region:eu")
+			Expect(err, ToBeNil)
+			again, err := tags.Key(ctx, "report", "tenant:1", "region:eu")
+			Expect(err, ToBeNil)
+			Expect(again, ToEqual, key)
+			Expect(strings.HasPrefix(key, "report@tenant:1="), ToEqual, true)
+		})
+
+		It("invalidates every key of a tag", func() {
+			key, _ := tags.Key(ctx, "report", "tenant:1")
+			other, _ := tags.Key(ctx, "report", "tenant:2")
+			Expect(c.SetContext(ctx, key, "stale"), ToBeNil)
+
+			Expect(tags.InvalidateTag(ctx, "tenant:1"), ToBeNil)
+			fresh, _ := tags.Key(ctx, "report", "tenant:1")
+			Expect(fresh == key, ToEqual, false)
+			_, err := c.Get(fresh)
+			Expect(api.IsNotFound(err), ToEqual, true)
+
+			unchanged, _ := tags.Key(ctx, "report", "tenant:2")
+			Expect(unchanged, ToEqual, other)
+		})
+
+		It("doesn't let tag names run together", func() {
+			Expect(c.Put("tag:x", &cache.Item{Value: 5}), ToBeNil)
+			Expect(c.Put("tag:y", &cache.Item{Value: 6}), ToBeNil)
+			Expect(c.Put("tag:x=5,y", &cache.Item{Value: 6}), ToBeNil)
+			two, err := tags.Key(ctx, "a", "x", "y")
+			Expect(err, ToBeNil)
+			one, err := tags.Key(ctx, "a", "x=5,y")
+			Expect(err, ToBeNil)
+			Expect(one == two, ToEqual, false)
+
+			Expect(c.Put("tag:b", &cache.Item{Value: 1}), ToBeNil)
+			tagged, _ := tags.Key(ctx, "a", "b")
+			untagged, _ := tags.Key(ctx, "a@b=1")
+			Expect(tagged == untagged, ToEqual, false)
+		})
+
+		It("invalidates tags that were never used", func() {
+			Expect(tags.InvalidateTag(ctx, "new"), ToBeNil)
+			_, err := tags.Version(ctx, "new")
+			Expect(err, ToBeNil)
+		})
+	})
+
+	Describe("WriteBehind", func() {
+		server := newFakeServer()
+		c := server.cache("behind")
+		ctx := context.Background()
+
+		It("collapses writes to the same key", func() {
+			w := cache.NewWriteBehind(c, time.Hour, 0)
+			before := server.requests
+			for i := 0; i < 100; i++ {
+				Expect(w.Set("progress", i), ToBeNil)
+			}
+			Expect(w.Set("other", "x"), ToBeNil)
+			Expect(w.Pending(), ToEqual, 2)
+			Expect(w.Flush(ctx), ToBeNil)
+			Expect(server.requests-before, ToEqual, 2)
+			value, _ := c.Get("progress")
+			Expect(value, ToEqual, float64(99))
+		})
+
+		It("flushes once enough keys are pending", func() {
+			w := cache.NewWriteBehind(c, time.Hour, 3)
+			defer w.Close(ctx)
+			for _, key := range []string{"a", "b", "c"} {
+				w.Set(key, key)
+			}
+			time.Sleep(100 * time.Millisecond)
+			Expect(w.Pending(), ToEqual, 0)
+			value, _ := c.Get("c")
+			Expect(value, ToEqual, "c")
+		})
+
+		It("reports failed background writes", func() {
+			broken := server.cache("behind")
+			broken.Settings.Token = ""
+			broken.Settings.Port = 1
+			w := cache.NewWriteBehind(broken, 10*time.Millisecond, 0)
+			failed := make(chan string, 1)
+			w.OnError = func(key string, item *cache.Item, err error) { failed <- key }
+			w.Set("lost", 1)
+			Expect(<-failed, ToEqual, "lost")
+			Expect(w.Close(ctx), ToBeNil)
+		})
+
+		It("flushes on Close and refuses later writes", func() {
+			w := cache.NewWriteBehind(c, time.Hour, 0)
+			w.Set("last", "words")
+			Expect(w.Close(ctx), ToBeNil)
+			value, _ := c.Get("last")
+			Expect(value, ToEqual, "words")
+			Expect(w.Set("late", 1), ToEqual, cache.ErrWriteBehindClosed)
+		})
+
+		It("flushes on maxPending alone without an interval", func() {
+			w := cache.NewWriteBehind(c, 0, 2)
+			w.Set("p", 1)
+			w.Set("q", 2)
+			time.Sleep(100 * time.Millisecond)
+			Expect(w.Pending(), ToEqual, 0)
+			Expect(w.Close(ctx), ToBeNil)
+		})
+
+		It("reports the writes Close couldn't make", func() {
+			w := cache.NewWriteBehind(c, 0, 0)
+			w.Set("unsaid", 1)
+			done, cancel := context.WithCancel(ctx)
+			cancel()
+			err := w.Close(done)
+			pending, ok := err.(*cache.PendingWritesError)
+			Expect(ok, ToEqual, true)
+			Expect(pending.Pending, ToEqual, 1)
+			Expect(errors.Is(err, context.Canceled), ToEqual, true)
+		})
+	})
+
+	Describe("Export and Import", func() {
+		server := newFakeServer()
+		src := server.cache("source")
+		dst := server.cache("destination")
+		ctx := context.Background()
+
+		It("copies items with their remaining TTL", func() {
+			Expect(src.SetContext(ctx, "a", "alpha", cache.TTL(time.Hour)), ToBeNil)
+			Expect(src.SetContext(ctx, "b", 2), ToBeNil)
+
+			buf := &bytes.Buffer{}
+			keys := slices.Values([]string{"a", "b", "missing"})
+			Expect(cache.Export(ctx, src, keys, buf), ToBeNil)
+			Expect(strings.Count(buf.String(), "\n"), ToEqual, 2)
+
+			Expect(cache.Import(ctx, dst, buf), ToBeNil)
+			meta, err := dst.GetItemMeta(ctx, "a")
+			Expect(err, ToBeNil)
+			Expect(meta.Value, ToEqual, "alpha")
+			left := time.Until(meta.Expires)
+			Expect(left > 58*time.Minute && left <= time.Hour, ToEqual, true)
+			value, err := dst.Get("b")
+			Expect(err, ToBeNil)
+			Expect(value, ToEqual, float64(2))
+		})
+
+		It("doesn't export items about to expire without an expiration", func() {
+			Expect(src.SetContext(ctx, "short", "x", cache.TTL(time.Second)), ToBeNil)
+
+			buf := &bytes.Buffer{}
+			Expect(cache.Export(ctx, src, slices.Values([]string{"short"}), buf), ToBeNil)
+			item := cache.ExportedItem{}
+			Expect(json.Unmarshal(buf.Bytes(), &item), ToBeNil)
+			Expect(item.ExpiresIn, ToEqual, int64(1))
+
+			Expect(cache.Import(ctx, dst, buf), ToBeNil)
+			meta, err := dst.GetItemMeta(ctx, "short")
+			Expect(api.IsNotFound(err) || (err == nil && time.Until(meta.Expires) <= time.Second), ToEqual, true)
+		})
+
+		It("skips items that expired since the export", func() {
+			old := `{"key":"old","value":"x","expires_in":60,"exported_at":"2000-01-01T00:00:00Z"}` + "\n"
+			Expect(cache.Import(ctx, dst, strings.NewReader(old)), ToBeNil)
+			_, err := dst.Get("old")
+			Expect(api.IsNotFound(err), ToEqual, true)
+		})
+	})
+
+	Describe("NewCache", func() {
+		It("makes requests with the given settings, environment and client", func() {
+			home, _ := os.MkdirTemp("", "iron-home")
+			defer os.RemoveAll(home)
+			defer os.Setenv("HOME", os.Getenv("HOME"))
+			os.Setenv("HOME", home)
+			os.WriteFile(filepath.Join(home, ".iron.json"), []byte(`{"staging": {"token": "staging-token"}}`), 0600)
+
+			server := newFakeServer()
+			settings := server.cache("options").Settings
+			settings.Token = ""
+			auth := []string{}
+			client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				auth = append(auth, req.Header.Get("Authorization"))
+				return http.DefaultTransport.RoundTrip(req)
+			})}
+			c, err := cache.NewCache("options", cache.WithSettings(&settings), cache.WithEnv("staging"), cache.WithClient(client))
+			Expect(err, ToBeNil)
+			Expect(c.Settings.Host, ToEqual, settings.Host)
+			Expect(c.Settings.Token, ToEqual, "staging-token")
+
+			Expect(c.Set("a", "alpha"), ToBeNil)
+			Expect(auth, ToDeepEqual, []string{"OAuth staging-token"})
+		})
+	})
 }
@@ -0,0 +1,228 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/iron-io/iron_go/api"
+)
+
+// A value longer than a Cache's ChunkSize is stored as chunk items plus a
+// manifest at the value's own key, written last so readers never see a
+// manifest whose chunks aren't there yet. Chunk keys include a random ID per
+// write, so a reader racing a rewrite can't mix chunks of both values.
+const chunksName = "chunks"
+
+// ErrChunkChecksum is returned when a reassembled value doesn't match the
+// checksums of its manifest.
+var ErrChunkChecksum = errors.New("cache: chunked value failed its checksum")
+
+type manifest struct {
+	ID     string   `json:"id"`
+	Size   int      `json:"size"`
+	Sum    string   `json:"sha256"`
+	Chunks []string `json:"chunks"` // sha256 of each chunk
+}
+
+func (m *manifest) chunkKey(key string, i int) string {
+	return fmt.Sprintf("%s:chunk:%s:%d", key, m.ID, i)
+}
+
+// chunkMissingError reports a value whose chunks expired or were evicted
+// before its manifest. It satisfies api.IsNotFound, so it reads as a miss.
+type chunkMissingError struct {
+	key string
+}
+
+func (e *chunkMissingError) Error() string {
+	return fmt.Sprintf("cache: chunks of %q are missing", e.key)
+}
+
+func (e *chunkMissingError) Response() *http.Response {
+	return &http.Response{Status: "404 Not Found", StatusCode: http.StatusNotFound}
+}
+
+// putChunked stores item, split into chunks if needed, and then deletes the
+// chunks of the value it replaced. Chunks get the item's expiration, so they
+// live as long as the manifest. If the manifest is refused, as by Add or
+// Replace, its chunks are deleted again.
+func (c *Cache) putChunked(ctx context.Context, key string, item *Item) error {
+	old := c.manifest(ctx, key)
+
+	value, ok := item.Value.(string)
+	if !ok || encodedLen(value) <= c.ChunkSize {
+		if err := c.putItem(ctx, key, item); err != nil {
+			return err
+		}
+		c.deleteChunks(ctx, key, old)
+		return nil
+	}
+
+	id, err := randomToken()
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(value))
+	m := &manifest{ID: id, Size: len(value), Sum: hex.EncodeToString(sum[:])}
+	chunks := splitChunks(value, c.ChunkSize)
+	for _, chunk := range chunks {
+		sum := sha256.Sum256([]byte(chunk))
+		m.Chunks = append(m.Chunks, hex.EncodeToString(sum[:]))
+	}
+
+	errs := make([]error, len(chunks))
+	c.batch(len(chunks), func(i int) {
+		errs[i] = c.putItem(ctx, m.chunkKey(key, i), &Item{Value: chunks[i], Expiration: item.Expiration})
+	})
+	for _, err := range errs {
+		if err != nil {
+			c.deleteChunks(ctx, key, m)
+			return err
+		}
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	err = c.putItem(ctx, key, &Item{
+		Value:      string(withHeader(chunksName, data)),
+		Expiration: item.Expiration,
+		Add:        item.Add,
+		Replace:    item.Replace,
+	})
+	if err != nil {
+		c.deleteChunks(ctx, key, m)
+		return err
+	}
+	c.deleteChunks(ctx, key, old)
+	return nil
+}
+
+// unchunk returns value, reassembled from its chunks if it is a manifest.
+func (c *Cache) unchunk(ctx context.Context, key string, value interface{}) (interface{}, error) {
+	m := c.parseManifest(value)
+	if m == nil {
+		return value, nil
+	}
+
+	chunks := make([]string, len(m.Chunks))
+	errs := make([]error, len(m.Chunks))
+	c.batch(len(m.Chunks), func(i int) {
+		out := struct {
+			Value interface{} `json:"value"`
+		}{}
+		errs[i] = c.item(m.chunkKey(key, i)).ReqContext(ctx, "GET", nil, &out)
+		chunks[i], _ = out.Value.(string)
+	})
+
+	buf := make([]byte, 0, m.Size)
+	for i, err := range errs {
+		if api.IsNotFound(err) {
+			return nil, &chunkMissingError{key: key}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if sum := sha256.Sum256([]byte(chunks[i])); hex.EncodeToString(sum[:]) != m.Chunks[i] {
+			return nil, fmt.Errorf("%w: chunk %d of %q", ErrChunkChecksum, i, key)
+		}
+		buf = append(buf, chunks[i]...)
+	}
+	if sum := sha256.Sum256(buf); hex.EncodeToString(sum[:]) != m.Sum {
+		return nil, fmt.Errorf("%w: %q", ErrChunkChecksum, key)
+	}
+	return string(buf), nil
+}
+
+// manifest returns the manifest stored at key, nil if there's none or it
+// can't be read.
+func (c *Cache) manifest(ctx context.Context, key string) *manifest {
+	out := struct {
+		Value interface{} `json:"value"`
+	}{}
+	if err := c.item(key).ReqContext(ctx, "GET", nil, &out); err != nil {
+		return nil
+	}
+	return c.parseManifest(out.Value)
+}
+
+func (c *Cache) parseManifest(value interface{}) *manifest {
+	str, ok := value.(string)
+	if !ok {
+		return nil
+	}
+	name, _, ok := splitHeader([]byte(str))
+	if !ok || name != chunksName {
+		return nil
+	}
+	data, err := withoutHeader(chunksName, []byte(str))
+	if err != nil {
+		return nil
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(data, m); err != nil || m.ID == "" {
+		return nil
+	}
+	return m
+}
+
+// deleteChunks deletes the chunks of m, if any, ignoring failures: orphaned
+// chunks expire along with the value they belonged to.
+func (c *Cache) deleteChunks(ctx context.Context, key string, m *manifest) {
+	if m == nil {
+		return
+	}
+	c.batch(len(m.Chunks), func(i int) {
+		c.deleteItem(ctx, m.chunkKey(key, i))
+	})
+}
+
+// splitChunks splits s on rune boundaries into chunks that are at most size
+// bytes once escaped as a JSON string, as they are sent.
+func splitChunks(s string, size int) []string {
+	chunks := []string{}
+	start, length := 0, 0
+	for i, r := range s {
+		n := runeEncodedLen(r, s[i:])
+		if length+n > size && i > start {
+			chunks = append(chunks, s[start:i])
+			start, length = i, 0
+		}
+		length += n
+	}
+	if start < len(s) {
+		chunks = append(chunks, s[start:])
+	}
+	return chunks
+}
+
+// encodedLen is the length of s escaped as a JSON string, without quotes.
+func encodedLen(s string) int {
+	length := 0
+	for i, r := range s {
+		length += runeEncodedLen(r, s[i:])
+	}
+	return length
+}
+
+// runeEncodedLen is how many bytes encoding/json writes for r, the first
+// rune of s. It errs on the long side.
+func runeEncodedLen(r rune, s string) int {
+	_, size := utf8.DecodeRuneInString(s)
+	switch {
+	case r == '"' || r == '\\' || r == '\n' || r == '\r' || r == '\t':
+		return 2
+	case r < 0x20 || r == '<' || r == '>' || r == '&' || r == '\u2028' || r == '\u2029':
+		return 6
+	case r == utf8.RuneError && size == 1:
+		return 6 // invalid UTF-8 becomes \ufffd
+	}
+	return size
+}
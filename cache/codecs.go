@@ -0,0 +1,358 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	// MsgPack encodes values as MessagePack.
+	MsgPack = Binary("msgpack", msgpack.Marshal, msgpack.Unmarshal)
+	// Proto encodes proto.Message values as protocol buffers.
+	Proto = Binary("proto", protoMarshal, protoUnmarshal)
+)
+
+// Values written by binary codecs are stored as text, base64 encoded behind
+// a header naming the codec, e.g. "#msgpack:gqFhAaFiAg==". JSON never starts
+// with the header marker, so headerless values are JSON.
+const headerMarker = "#"
+
+// MaxDecompressedSize is the most a Compressed value may expand to when
+// read, so a small value can't exhaust memory.
+const MaxDecompressedSize = 64 << 20
+
+// ErrDecompressedTooLarge is returned when reading a Compressed value that
+// expands past MaxDecompressedSize.
+var ErrDecompressedTooLarge = errors.New("cache: decompressed value is larger than MaxDecompressedSize")
+
+// Compression is a compression algorithm for Compressed.
+type Compression string
+
+const (
+	Gzip Compression = "gzip"
+	Zstd Compression = "zstd"
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+func init() {
+	RegisterCodec(Gob)
+	RegisterCodec(MsgPack)
+	RegisterCodec(Proto)
+}
+
+// RegisterCodec makes a Codec created by Binary known to Detect by its name.
+func RegisterCodec(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[codec.Name] = codec
+}
+
+// Binary returns a Codec named name that stores the bytes produced by marshal
+// base64 encoded behind a header, so binary output survives being stored as
+// a string. Unmarshal also accepts headerless bytes as written by older
+// versions of Gob.
+func Binary(name string, marshal func(interface{}) ([]byte, error), unmarshal func([]byte, interface{}) error) Codec {
+	return Codec{
+		Name: name,
+		Marshal: func(v interface{}) ([]byte, error) {
+			raw, err := marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			return withHeader(name, raw), nil
+		},
+		Unmarshal: func(data []byte, v interface{}) error {
+			raw, err := withoutHeader(name, data)
+			if err != nil {
+				return err
+			}
+			return unmarshal(raw, v)
+		},
+		marshalRaw:   marshal,
+		unmarshalRaw: unmarshal,
+	}
+}
+
+// Compressed wraps codec so its output is compressed with alg. The result is
+// named after both, e.g. "gzip+json". Values are read back only if they
+// decompress to at most MaxDecompressedSize.
+func Compressed(codec Codec, alg Compression) Codec {
+	name := string(alg) + "+" + codec.codecName()
+	marshal := func(v interface{}) ([]byte, error) {
+		raw, err := codec.raw(v)
+		if err != nil {
+			return nil, err
+		}
+		return compress(alg, raw)
+	}
+	unmarshal := func(data []byte, v interface{}) error {
+		raw, err := decompress(alg, data)
+		if err != nil {
+			return err
+		}
+		return codec.fromRaw(raw, v)
+	}
+	compressed := Binary(name, marshal, unmarshal)
+	if codec.marshalFor != nil {
+		compressed.marshalFor = func(cacheName, key string, v interface{}) ([]byte, error) {
+			raw, err := codec.rawFor(cacheName, key, v)
+			if err != nil {
+				return nil, err
+			}
+			packed, err := compress(alg, raw)
+			if err != nil {
+				return nil, err
+			}
+			return withHeader(name, packed), nil
+		}
+		compressed.unmarshalFor = func(cacheName, key string, data []byte, v interface{}) error {
+			packed, err := withoutHeader(name, data)
+			if err != nil {
+				return err
+			}
+			raw, err := decompress(alg, packed)
+			if err != nil {
+				return err
+			}
+			return codec.unmarshalAt(cacheName, key, raw, v)
+		}
+	}
+	return compressed
+}
+
+// Detect returns a Codec that writes with codec, but reads values written by
+// codec itself or any registered codec, including any Compressed combination
+// of them. Headerless values are read as JSON.
+func Detect(codec Codec) Codec {
+	return Codec{
+		Name:    codec.Name,
+		Marshal: codec.Marshal,
+		Unmarshal: func(data []byte, v interface{}) error {
+			name, _, ok := splitHeader(data)
+			if !ok {
+				return JSON.Unmarshal(data, v)
+			}
+			found, err := lookupCodec(name)
+			if err != nil {
+				return err
+			}
+			return found.Unmarshal(data, v)
+		},
+		marshalRaw:   codec.marshalRaw,
+		unmarshalRaw: codec.unmarshalRaw,
+		marshalFor:   codec.marshalFor,
+		unmarshalFor: func(cacheName, key string, data []byte, v interface{}) error {
+			name, _, ok := splitHeader(data)
+			if !ok {
+				return JSON.Unmarshal(data, v)
+			}
+			if name == codec.Name {
+				return codec.unmarshalAt(cacheName, key, data, v)
+			}
+			found, err := lookupCodec(name)
+			if err != nil {
+				return err
+			}
+			return found.unmarshalAt(cacheName, key, data, v)
+		},
+	}
+}
+
+func lookupCodec(name string) (Codec, error) {
+	if i := strings.Index(name, "+"); i >= 0 {
+		inner, err := lookupCodec(name[i+1:])
+		if err != nil {
+			return Codec{}, err
+		}
+		alg := Compression(name[:i])
+		if alg != Gzip && alg != Zstd {
+			return Codec{}, fmt.Errorf("cache: unknown compression %q", alg)
+		}
+		return Compressed(inner, alg), nil
+	}
+	if name == "json" {
+		return JSON, nil
+	}
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, found := codecs[name]
+	if !found {
+		return Codec{}, fmt.Errorf("cache: unknown codec %q", name)
+	}
+	return codec, nil
+}
+
+func (cd Codec) codecName() string {
+	if cd.Name == "" {
+		return "json"
+	}
+	return cd.Name
+}
+
+// raw marshals v without the text encoding added by Binary.
+func (cd Codec) raw(v interface{}) ([]byte, error) {
+	if cd.marshalRaw != nil {
+		return cd.marshalRaw(v)
+	}
+	return cd.Marshal(v)
+}
+
+func (cd Codec) fromRaw(data []byte, v interface{}) error {
+	if cd.unmarshalRaw != nil {
+		return cd.unmarshalRaw(data, v)
+	}
+	return cd.Unmarshal(data, v)
+}
+
+// rawFor is raw for storing at key of cacheName, for codecs that depend on
+// where the value is stored. Their output is taken as is.
+func (cd Codec) rawFor(cacheName, key string, v interface{}) ([]byte, error) {
+	if cd.marshalFor != nil {
+		return cd.marshalFor(cacheName, key, v)
+	}
+	return cd.raw(v)
+}
+
+// unmarshalAt unmarshals data read from key of cacheName.
+func (cd Codec) unmarshalAt(cacheName, key string, data []byte, v interface{}) error {
+	if cd.unmarshalFor != nil {
+		return cd.unmarshalFor(cacheName, key, data, v)
+	}
+	return cd.Unmarshal(data, v)
+}
+
+func withHeader(name string, raw []byte) []byte {
+	prefix := headerMarker + name + ":"
+	out := make([]byte, len(prefix)+base64.StdEncoding.EncodedLen(len(raw)))
+	copy(out, prefix)
+	base64.StdEncoding.Encode(out[len(prefix):], raw)
+	return out
+}
+
+func withoutHeader(name string, data []byte) ([]byte, error) {
+	found, payload, ok := splitHeader(data)
+	if !ok {
+		return data, nil
+	}
+	if found != name {
+		return nil, fmt.Errorf("cache: value was written by codec %q, not %q", found, name)
+	}
+	raw := make([]byte, base64.StdEncoding.DecodedLen(len(payload)))
+	n, err := base64.StdEncoding.Decode(raw, payload)
+	return raw[:n], err
+}
+
+// splitHeader returns the codec name and payload of a value written by a
+// Binary codec.
+func splitHeader(data []byte) (name string, payload []byte, ok bool) {
+	if !bytes.HasPrefix(data, []byte(headerMarker)) {
+		return "", nil, false
+	}
+	i := bytes.IndexByte(data, ':')
+	if i < 0 {
+		return "", nil, false
+	}
+	return string(data[len(headerMarker):i]), data[i+1:], true
+}
+
+func compress(alg Compression, raw []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+	switch alg {
+	case Gzip:
+		w = gzip.NewWriter(buf)
+	case Zstd:
+		zw, err := zstd.NewWriter(buf)
+		if err != nil {
+			return nil, err
+		}
+		w = zw
+	default:
+		return nil, fmt.Errorf("cache: unknown compression %q", alg)
+	}
+	if _, err := w.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(alg Compression, data []byte) ([]byte, error) {
+	switch alg {
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readLimited(r)
+	case Zstd:
+		r, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderMaxMemory(MaxDecompressedSize))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readLimited(r)
+	}
+	return nil, fmt.Errorf("cache: unknown compression %q", alg)
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	out, err := io.ReadAll(io.LimitReader(r, MaxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > MaxDecompressedSize {
+		return nil, ErrDecompressedTooLarge
+	}
+	return out, nil
+}
+
+var errNotProto = errors.New("cache: Proto codec needs a proto.Message")
+
+func protoMarshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, errNotProto
+	}
+	return proto.Marshal(m)
+}
+
+// protoUnmarshal also accepts a pointer to a message pointer, as Typed
+// passes for a Typed[*pb.Message], allocating the message.
+func protoUnmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Ptr {
+		return errNotProto
+	}
+	msg := reflect.New(rv.Elem().Type().Elem())
+	m, ok := msg.Interface().(proto.Message)
+	if !ok {
+		return errNotProto
+	}
+	if err := proto.Unmarshal(data, m); err != nil {
+		return err
+	}
+	rv.Elem().Set(msg)
+	return nil
+}
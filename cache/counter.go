@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/iron-io/iron_go/api"
+)
+
+// Counter is an integer item changed atomically on the server.
+//
+//	requests := cache.NewCounter(c, "requests:"+customer)
+//	requests.Init = time.Hour
+//	n, err := requests.Add(ctx, 1)
+type Counter struct {
+	Cache *Cache
+	Key   string
+	// Init, when set, makes Add and Decr create a missing counter as 0,
+	// expiring after Init, instead of failing with an error satisfying
+	// api.IsNotFound.
+	Init time.Duration
+}
+
+// NewCounter returns the Counter stored at key of c.
+func NewCounter(c *Cache, key string) *Counter {
+	return &Counter{Cache: c, Key: key}
+}
+
+// Add adds delta, which may be negative, returning the new value.
+func (n *Counter) Add(ctx context.Context, delta int64) (int64, error) {
+	if n.Init > 0 {
+		return n.Cache.incrementOrCreate(ctx, n.Key, delta, 0, n.Init)
+	}
+	value, err := n.Cache.IncrementContext(ctx, n.Key, delta)
+	if err != nil {
+		return 0, err
+	}
+	return integer(n.Key, value)
+}
+
+// Decr subtracts delta, returning the new value.
+func (n *Counter) Decr(ctx context.Context, delta int64) (int64, error) {
+	return n.Add(ctx, -delta)
+}
+
+// Get returns the current value, 0 if the counter doesn't exist.
+func (n *Counter) Get(ctx context.Context) (int64, error) {
+	value, err := n.Cache.GetContext(ctx, n.Key)
+	if api.IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return integer(n.Key, value)
+}
+
+// Reset sets the counter to 0, expiring after Init if set.
+func (n *Counter) Reset(ctx context.Context) error {
+	return n.SetWithTTL(ctx, 0, n.Init)
+}
+
+// SetWithTTL sets the counter to value, expiring after ttl. A ttl of zero
+// keeps it for IronCache's default.
+func (n *Counter) SetWithTTL(ctx context.Context, value int64, ttl time.Duration) error {
+	return n.Cache.PutContext(ctx, n.Key, &Item{Value: value, Expiration: ttl})
+}
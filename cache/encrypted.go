@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// encryptedName is the header of values written by Encrypted codecs.
+const encryptedName = "aesgcm"
+
+var errEncryptedNeedsKey = errors.New("cache: Encrypted codec can only be used through Codec.Put, Codec.Get or Typed")
+
+// Keyring holds the AES keys used by Encrypted, by ID. Values are sealed with
+// the current key and opened with whichever key sealed them, so a key can be
+// rotated while values sealed with the old one are still cached.
+type Keyring struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyring returns a Keyring using key, known as id, for new values. The
+// key must be 16, 24 or 32 bytes long, selecting AES-128, AES-192 or AES-256.
+func NewKeyring(id string, key []byte) (*Keyring, error) {
+	k := &Keyring{keys: map[string][]byte{}}
+	if err := k.Rotate(id, key); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Add makes key known as id, for opening values sealed with it.
+func (k *Keyring) Add(id string, key []byte) error {
+	if id == "" || len(id) > 255 {
+		return fmt.Errorf("cache: key ID must be 1 to 255 bytes, not %d", len(id))
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return fmt.Errorf("cache: key %q: %v", id, err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = append([]byte(nil), key...)
+	return nil
+}
+
+// Rotate adds key as id and seals new values with it from now on.
+func (k *Keyring) Rotate(id string, key []byte) error {
+	if err := k.Add(id, key); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.current = id
+	return nil
+}
+
+// Current returns the ID of the key sealing new values.
+func (k *Keyring) Current() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+func (k *Keyring) key(id string) ([]byte, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+// TamperError is returned when an encrypted value fails authentication: it
+// was modified, sealed with a different key, or copied from another item.
+type TamperError struct {
+	Cache string
+	Key   string
+	KeyID string
+}
+
+func (e *TamperError) Error() string {
+	return fmt.Sprintf("cache: value of %q in cache %q failed authentication with key %q", e.Key, e.Cache, e.KeyID)
+}
+
+// Encrypted wraps inner so values are sealed with AES-GCM using the current
+// key of keyring. The cache name and item key are authenticated along with
+// the value, so a value copied to another item fails to open with a
+// *TamperError.
+//
+// The result needs to know where a value is stored, so it only works through
+// Codec.Put, Codec.Get and Typed, not by calling Marshal or Unmarshal.
+func Encrypted(inner Codec, keyring *Keyring) Codec {
+	return Codec{
+		Name: encryptedName,
+		Marshal: func(interface{}) ([]byte, error) {
+			return nil, errEncryptedNeedsKey
+		},
+		Unmarshal: func([]byte, interface{}) error {
+			return errEncryptedNeedsKey
+		},
+		marshalFor: func(cacheName, key string, v interface{}) ([]byte, error) {
+			plain, err := inner.raw(v)
+			if err != nil {
+				return nil, err
+			}
+			return seal(keyring, cacheName, key, plain)
+		},
+		unmarshalFor: func(cacheName, key string, data []byte, v interface{}) error {
+			plain, err := open(keyring, cacheName, key, data)
+			if err != nil {
+				return err
+			}
+			return inner.fromRaw(plain, v)
+		},
+	}
+}
+
+// seal returns the header, followed by the key ID length, the key ID, the
+// nonce and the sealed plaintext.
+func seal(keyring *Keyring, cacheName, key string, plain []byte) ([]byte, error) {
+	id := keyring.Current()
+	aead, err := keyAEAD(keyring, id)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, 1+len(id)+aead.NonceSize()+len(plain)+aead.Overhead())
+	out = append(out, byte(len(id)))
+	out = append(out, id...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = append(out, nonce...)
+	out = aead.Seal(out, nonce, plain, associatedData(cacheName, key, id))
+	return withHeader(encryptedName, out), nil
+}
+
+func open(keyring *Keyring, cacheName, key string, data []byte) ([]byte, error) {
+	name, _, ok := splitHeader(data)
+	if !ok || name != encryptedName {
+		return nil, fmt.Errorf("cache: value of %q is not encrypted", key)
+	}
+	sealed, err := withoutHeader(encryptedName, data)
+	if err != nil || len(sealed) < 1 || len(sealed) < 1+int(sealed[0]) {
+		return nil, &TamperError{Cache: cacheName, Key: key}
+	}
+	id := string(sealed[1 : 1+int(sealed[0])])
+	sealed = sealed[1+len(id):]
+
+	aead, err := keyAEAD(keyring, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, &TamperError{Cache: cacheName, Key: key, KeyID: id}
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, associatedData(cacheName, key, id))
+	if err != nil {
+		return nil, &TamperError{Cache: cacheName, Key: key, KeyID: id}
+	}
+	return plain, nil
+}
+
+func keyAEAD(keyring *Keyring, id string) (cipher.AEAD, error) {
+	key, ok := keyring.key(id)
+	if !ok {
+		return nil, fmt.Errorf("cache: unknown encryption key %q", id)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// associatedData binds a sealed value to its item. Names are length prefixed
+// so no two items share the same associated data.
+func associatedData(cacheName, key, id string) []byte {
+	return []byte(fmt.Sprintf("%d:%s%d:%s%s", len(cacheName), cacheName, len(key), key, id))
+}
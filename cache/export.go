@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"iter"
+	"time"
+
+	"github.com/iron-io/iron_go/api"
+)
+
+// ExportedItem is one line written by Export.
+type ExportedItem struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+	// ExpiresIn is how many seconds the item had left when exported, rounded
+	// up, or zero if it was kept for IronCache's default.
+	ExpiresIn  int64     `json:"expires_in,omitempty"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// Export writes the items at keys to w, one JSON object per line. IronCache
+// can't list keys, so they have to come from elsewhere, such as a manifest
+// of the keys an application uses. Missing keys are skipped.
+func Export(ctx context.Context, c *Cache, keys iter.Seq[string], w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for key := range keys {
+		meta, err := c.GetItemMeta(ctx, key)
+		if api.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		item := ExportedItem{Key: key, Value: meta.Value, ExportedAt: now}
+		if left := meta.Expires.Sub(now); left > 0 && left <= maxExpiration {
+			item.ExpiresIn = int64(expiresIn(left))
+		}
+		if err := enc.Encode(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import puts the items written by Export into c. The time since each was
+// exported is taken off its expiration, and items that expired since are
+// skipped.
+func Import(ctx context.Context, c *Cache, r io.Reader) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		item := ExportedItem{}
+		err := dec.Decode(&item)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		expiration := time.Duration(0)
+		if item.ExpiresIn > 0 {
+			expiration = time.Duration(item.ExpiresIn)*time.Second - time.Since(item.ExportedAt)
+			if expiration < time.Second {
+				continue
+			}
+		}
+		if err := c.PutContext(ctx, item.Key, &Item{Value: item.Value, Expiration: expiration}); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,324 @@
+// Package httpcache caches HTTP responses in IronCache, so every process
+// calling a slow API shares the responses any of them got.
+//
+//	client := &http.Client{Transport: httpcache.NewTransport(c)}
+//
+// It's a shared cache in the sense of RFC 9111: responses marked private,
+// and responses to requests with credentials that aren't explicitly marked
+// shareable, aren't stored.
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iron-io/iron_go/cache"
+)
+
+// DefaultMaxBodySize is the largest body stored when MaxBodySize isn't set.
+// Bodies are stored base64 encoded in a JSON entry, which has to fit in one
+// IronCache item of at most 1 MiB. Larger ones need a Cache with ChunkSize.
+const DefaultMaxBodySize = 512 << 10
+
+// Entry is a stored response, or the list of headers its variants vary by.
+type Entry struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// Date is when the response was received or last revalidated.
+	Date time.Time
+	// FreshUntil is when the response has to be revalidated.
+	FreshUntil time.Time
+	// Vary is set instead of the response on the URL's own key when
+	// responses vary, naming the request headers picking the variant.
+	Vary []string
+}
+
+// Transport is an http.RoundTripper answering GET requests from the cache
+// while the stored response is fresh, and revalidating it with its ETag or
+// Last-Modified once it's stale.
+type Transport struct {
+	Entries *cache.Typed[Entry]
+	// Transport makes the requests, http.DefaultTransport if nil.
+	Transport http.RoundTripper
+	// MaxBodySize is the largest body stored, DefaultMaxBodySize if zero.
+	MaxBodySize int64
+	// VaryBy are request headers that always pick a different variant, on
+	// top of those the response's Vary header names.
+	VaryBy []string
+	// KeepStale is how long a stale response with a validator is kept past
+	// its freshness for revalidation.
+	KeepStale time.Duration
+	// OnError, when set, is told about failures reading or storing entries.
+	// Either way the request is still made, so they only cost a miss.
+	OnError func(req *http.Request, err error)
+}
+
+// NewTransport returns a Transport storing responses in c as JSON. Keys are
+// built from URLs, and those longer than cache.MaxKeyLength are hashed.
+func NewTransport(c *cache.Cache) *Transport {
+	return &Transport{
+		Entries:   cache.NewTyped[Entry](c, cache.JSON),
+		KeepStale: 24 * time.Hour,
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqCC := cacheControl(req.Header)
+	if req.Method != "GET" || req.Header.Get("Range") != "" || reqCC.has("no-store") {
+		return t.transport().RoundTrip(req)
+	}
+	ctx := req.Context()
+
+	key, entry, found := t.lookup(req)
+	if found && !reqCC.has("no-cache") && time.Now().Before(entry.FreshUntil) {
+		return entry.response(req), nil
+	}
+
+	if found && (entry.Header.Get("ETag") != "" || entry.Header.Get("Last-Modified") != "") {
+		conditional := req.Clone(ctx)
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			conditional.Header.Set("If-None-Match", etag)
+		}
+		if modified := entry.Header.Get("Last-Modified"); modified != "" {
+			conditional.Header.Set("If-Modified-Since", modified)
+		}
+		res, err := t.transport().RoundTrip(conditional)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode == http.StatusNotModified {
+			res.Body.Close()
+			// the 304 is a new response, the stored Age no longer applies
+			entry.Header.Del("Age")
+			for name, values := range res.Header {
+				entry.Header[name] = values
+			}
+			if t.freshen(&entry, req) {
+				t.store(req, key, entry)
+			}
+			return entry.response(req), nil
+		}
+		return t.keep(req, res)
+	}
+
+	res, err := t.transport().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	return t.keep(req, res)
+}
+
+// keep stores res if it may be and returns it, with its body intact.
+func (t *Transport) keep(req *http.Request, res *http.Response) (*http.Response, error) {
+	entry := Entry{Status: res.StatusCode, Header: res.Header.Clone()}
+	if !cacheableStatus[res.StatusCode] || !t.freshen(&entry, req) {
+		return res, nil
+	}
+
+	limit := t.MaxBodySize
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, limit+1))
+	if err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		res.Body = readCloser{io.MultiReader(bytes.NewReader(body), res.Body), res.Body}
+		return res, nil
+	}
+	res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	entry.Body = body
+
+	key := t.key(req, nil)
+	if vary := t.vary(res.Header); len(vary) > 0 {
+		for _, name := range vary {
+			if name == "*" {
+				return res, nil
+			}
+		}
+		t.store(req, key, Entry{Vary: vary, FreshUntil: entry.FreshUntil, Header: entry.Header})
+		key = t.key(req, vary)
+	}
+	t.store(req, key, entry)
+	return res, nil
+}
+
+// freshen sets the entry's dates from its headers, which after a
+// revalidation are the stored ones updated by the 304 (RFC 9111 4.3.4),
+// reporting whether it may be stored at all.
+func (t *Transport) freshen(entry *Entry, req *http.Request) bool {
+	cc := cacheControl(entry.Header)
+	if cc.has("no-store") || cc.has("private") {
+		return false
+	}
+	if req.Header.Get("Authorization") != "" && !cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return false
+	}
+
+	now := time.Now()
+	lifetime := time.Duration(0)
+	if age, ok := cc.seconds("s-maxage"); ok {
+		lifetime = age
+	} else if age, ok := cc.seconds("max-age"); ok {
+		lifetime = age
+	} else if expires, err := http.ParseTime(entry.Header.Get("Expires")); err == nil {
+		date, err := http.ParseTime(entry.Header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		lifetime = expires.Sub(date)
+	}
+	if cc.has("no-cache") || lifetime < 0 {
+		lifetime = 0
+	}
+	if age, err := strconv.Atoi(entry.Header.Get("Age")); err == nil {
+		lifetime -= time.Duration(age) * time.Second
+	}
+
+	entry.Date = now
+	entry.FreshUntil = now.Add(lifetime)
+	return lifetime > 0 || entry.Header.Get("ETag") != "" || entry.Header.Get("Last-Modified") != ""
+}
+
+func (t *Transport) lookup(req *http.Request) (key string, entry Entry, found bool) {
+	key = t.key(req, nil)
+	entry, found, err := t.Entries.Get(req.Context(), key)
+	if err != nil || !found {
+		t.report(req, err)
+		return key, entry, false
+	}
+	if len(entry.Vary) > 0 {
+		key = t.key(req, entry.Vary)
+		entry, found, err = t.Entries.Get(req.Context(), key)
+		if err != nil {
+			t.report(req, err)
+			return key, entry, false
+		}
+	}
+	return key, entry, found
+}
+
+// store keeps entry until it's stale, or KeepStale longer if it can be
+// revalidated. Failures only cost a later miss, so they are only reported.
+func (t *Transport) store(req *http.Request, key string, entry Entry) {
+	ttl := time.Until(entry.FreshUntil)
+	if entry.Header.Get("ETag") != "" || entry.Header.Get("Last-Modified") != "" {
+		ttl += t.KeepStale
+	}
+	if ttl < time.Second {
+		return
+	}
+	t.report(req, t.Entries.Set(req.Context(), key, entry, cache.TTL(ttl)))
+}
+
+func (t *Transport) report(req *http.Request, err error) {
+	if err != nil && t.OnError != nil {
+		t.OnError(req, err)
+	}
+}
+
+// key is the method and URL of req, followed by a hash of the request
+// headers picking the variant.
+func (t *Transport) key(req *http.Request, vary []string) string {
+	key := req.Method + " " + req.URL.String()
+	names := append(append([]string{}, t.VaryBy...), vary...)
+	if len(names) == 0 {
+		return key
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		io.WriteString(h, name+": "+strings.Join(req.Header.Values(name), ", ")+"\n")
+	}
+	return key + " " + hex.EncodeToString(h.Sum(nil))
+}
+
+// vary returns the canonical header names of the response's Vary header.
+func (t *Transport) vary(header http.Header) []string {
+	names := []string{}
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+func (t *Transport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (e *Entry) response(req *http.Request) *http.Response {
+	header := e.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(time.Since(e.Date).Seconds())))
+	return &http.Response{
+		Status:        strconv.Itoa(e.Status) + " " + http.StatusText(e.Status),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// cacheableStatus are the statuses cacheable by default, RFC 9110 15.1.
+var cacheableStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true,
+	308: true, 404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+type directives map[string]string
+
+func cacheControl(header http.Header) directives {
+	d := directives{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name != "" {
+				d[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return d
+}
+
+func (d directives) has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+func (d directives) seconds(name string) (time.Duration, bool) {
+	arg, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,214 @@
+package httpcache_test
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/iron-io/iron_go/cache"
+	"github.com/iron-io/iron_go/cache/httpcache"
+	"github.com/iron-io/iron_go/config"
+	. "github.com/jeffh/go.bdd"
+)
+
+func TestEverything(t *testing.T) {}
+
+// fakeCache serves the item calls of the IronCache API from memory.
+type fakeCache struct {
+	*httptest.Server
+	sync.Mutex
+	items map[string]interface{}
+}
+
+func newFakeCache() *fakeCache {
+	f := &fakeCache{items: map[string]interface{}{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.Lock()
+		defer f.Unlock()
+		key := r.URL.Path[strings.Index(r.URL.Path, "/items/")+len("/items/"):]
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case "GET":
+			value, found := f.items[key]
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"msg": "Key not found."})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"key": key, "value": value})
+		case "PUT":
+			body, _ := io.ReadAll(r.Body)
+			if len(body) > 1<<20 {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"msg": "Value is too large."})
+				return
+			}
+			in := struct{ Value interface{} }{}
+			json.Unmarshal(body, &in)
+			f.items[key] = in.Value
+			json.NewEncoder(w).Encode(map[string]string{"msg": "Stored."})
+		}
+	}))
+	return f
+}
+
+func (f *fakeCache) cache() *cache.Cache {
+	host, port, _ := net.SplitHostPort(f.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return &cache.Cache{Name: "http", Settings: config.Settings{
+		Token: "token", ProjectId: "4f2a7c1b9e8d6f3a2b1c0d9e",
+		Host: host, Port: uint16(portNum), Scheme: "http", ApiVersion: "1",
+	}}
+}
+
+// origin counts the requests it serves, answering with the headers set for
+// the path.
+type origin struct {
+	*httptest.Server
+	sync.Mutex
+	hits, revalidated int
+}
+
+func newOrigin() *origin {
+	o := &origin{}
+	o.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o.Lock()
+		o.hits++
+		o.Unlock()
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				o.Lock()
+				o.revalidated++
+				o.Unlock()
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/aged":
+			// stale on arrival, the 304 doesn't repeat the Cache-Control
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Age", "60")
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+			w.Write([]byte(r.Header.Get("Accept-Language")))
+			return
+		case "/big":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte(strings.Repeat("x", 100)))
+			return
+		case "/huge":
+			n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte(strings.Repeat("x", n)))
+			return
+		}
+		w.Write([]byte("body of " + r.URL.Path))
+	}))
+	return o
+}
+
+func get(client *http.Client, url string, header ...string) string {
+	req, _ := http.NewRequest("GET", url, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	res, err := client.Do(req)
+	Expect(err, ToBeNil)
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	return string(body)
+}
+
+func init() {
+	defer PrintSpecReport()
+
+	Describe("Transport", func() {
+		f := newFakeCache()
+		o := newOrigin()
+		transport := httpcache.NewTransport(f.cache())
+		transport.MaxBodySize = 50
+		client := &http.Client{Transport: transport}
+
+		It("answers fresh responses from the cache", func() {
+			Expect(get(client, o.URL+"/fresh"), ToEqual, "body of /fresh")
+			Expect(get(client, o.URL+"/fresh"), ToEqual, "body of /fresh")
+			Expect(o.hits, ToEqual, 1)
+		})
+
+		It("revalidates stale responses with their ETag", func() {
+			o.hits = 0
+			Expect(get(client, o.URL+"/etag"), ToEqual, "body of /etag")
+			Expect(get(client, o.URL+"/etag"), ToEqual, "body of /etag")
+			Expect(o.hits, ToEqual, 2)
+			Expect(o.revalidated, ToEqual, 1)
+		})
+
+		It("keeps the stored max-age after a 304 without Cache-Control", func() {
+			o.hits = 0
+			Expect(get(client, o.URL+"/aged"), ToEqual, "body of /aged")
+			Expect(get(client, o.URL+"/aged"), ToEqual, "body of /aged")
+			Expect(get(client, o.URL+"/aged"), ToEqual, "body of /aged")
+			Expect(o.hits, ToEqual, 2)
+		})
+
+		It("doesn't store private responses", func() {
+			o.hits = 0
+			get(client, o.URL+"/private")
+			get(client, o.URL+"/private")
+			Expect(o.hits, ToEqual, 2)
+		})
+
+		It("keeps a variant per Vary header value", func() {
+			o.hits = 0
+			Expect(get(client, o.URL+"/vary", "Accept-Language", "en"), ToEqual, "en")
+			Expect(get(client, o.URL+"/vary", "Accept-Language", "de"), ToEqual, "de")
+			Expect(get(client, o.URL+"/vary", "Accept-Language", "en"), ToEqual, "en")
+			Expect(get(client, o.URL+"/vary", "Accept-Language", "de"), ToEqual, "de")
+			Expect(o.hits, ToEqual, 2)
+		})
+
+		It("passes bodies over MaxBodySize through", func() {
+			o.hits = 0
+			Expect(len(get(client, o.URL+"/big")), ToEqual, 100)
+			Expect(len(get(client, o.URL+"/big")), ToEqual, 100)
+			Expect(o.hits, ToEqual, 2)
+		})
+
+		It("stores bodies up to DefaultMaxBodySize in one item", func() {
+			o.hits = 0
+			client := &http.Client{Transport: httpcache.NewTransport(f.cache())}
+			url := o.URL + "/huge?n=" + strconv.Itoa(httpcache.DefaultMaxBodySize)
+			Expect(len(get(client, url)), ToEqual, httpcache.DefaultMaxBodySize)
+			Expect(len(get(client, url)), ToEqual, httpcache.DefaultMaxBodySize)
+			Expect(o.hits, ToEqual, 1)
+		})
+
+		It("reports entries it couldn't store", func() {
+			failed := make(chan error, 1)
+			large := httpcache.NewTransport(f.cache())
+			large.MaxBodySize = 2 << 20
+			large.OnError = func(req *http.Request, err error) { failed <- err }
+			client := &http.Client{Transport: large}
+			Expect(len(get(client, o.URL+"/huge?n=1500000")), ToEqual, 1500000)
+			Expect(<-failed, ToNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,279 @@
+// Package idempotency makes HTTP handlers safe to retry: a request carrying
+// an Idempotency-Key header runs the handler once, and retries with the
+// same key get the recorded response instead of running it again.
+//
+//	keys := idempotency.New(c)
+//	mux.Handle("/payments", keys.Middleware(payments))
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/iron-io/iron_go/cache"
+)
+
+// Record is what's stored for an idempotency key.
+type Record struct {
+	// Fingerprint identifies the request that claimed the key.
+	Fingerprint string
+	// Done is set once the handler finished.
+	Done bool
+	// Unrecorded is set along with Done when the response couldn't be kept,
+	// as it was too large or storing it failed. Retries get 409 Conflict
+	// rather than running the handler again.
+	Unrecorded bool
+	Status     int
+	Header     http.Header
+	Body       []byte
+}
+
+// unreplayed are response headers never recorded: hop-by-hop ones, and
+// cookies, which may belong to the client rather than the response.
+var unreplayed = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade", "Set-Cookie",
+}
+
+// Keys is an idempotency middleware keeping its records in a Cache.
+type Keys struct {
+	Records *cache.Typed[Record]
+	// Header is the request header carrying the key.
+	Header string
+	// KeyPrefix is put in front of keys to get cache keys.
+	KeyPrefix string
+	// Scope, when set, returns who a request is from, so different clients
+	// can't replay each other's responses by reusing a key.
+	Scope func(*http.Request) string
+	// TTL is how long responses are kept for replay.
+	TTL time.Duration
+	// Timeout is how long a key stays claimed while its request is handled,
+	// so a key isn't stuck when the process handling it dies. Handlers
+	// should finish within it.
+	Timeout time.Duration
+	// Wait is how long a duplicate of a request still being handled waits
+	// for its response before getting 409 Conflict. Zero answers 409 right
+	// away.
+	Wait time.Duration
+	// MaxBodySize is the largest request body read for the fingerprint.
+	// Larger requests are refused with 413.
+	MaxBodySize int64
+	// MaxResponseSize is the largest response body recorded for replay.
+	// Retries of larger responses get 409 Conflict, see Record.Unrecorded.
+	MaxResponseSize int64
+	// OnError, when set, is told about failures recording or releasing
+	// keys.
+	OnError func(r *http.Request, err error)
+}
+
+// New returns Keys recording responses in c as JSON for a day.
+func New(c *cache.Cache) *Keys {
+	return &Keys{
+		Records:         cache.NewTyped[Record](c, cache.JSON),
+		Header:          "Idempotency-Key",
+		KeyPrefix:       "idempotency:",
+		TTL:             24 * time.Hour,
+		Timeout:         time.Minute,
+		MaxBodySize:     1 << 20,
+		MaxResponseSize: 256 << 10,
+	}
+}
+
+// Middleware runs next once per idempotency key. Requests without the
+// header are passed through.
+//
+// The first request claims the key with an Add and records the response once
+// next returns. Retries get the recorded response back, marked with an
+// Idempotent-Replayed header; while the first is still running they get 409
+// Conflict, after waiting for up to Wait. A key reused for a request with a
+// different method, path or body gets 422 Unprocessable Entity. Server errors
+// aren't recorded, so the request can be retried. Only the headers next set
+// are recorded, less hop-by-hop headers and Set-Cookie.
+func (k *Keys) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(k.Header)
+		if id == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, k.MaxBodySize+1))
+		r.Body.Close()
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if int64(len(body)) > k.MaxBodySize {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := r.Context()
+		key := k.key(r, id)
+		fingerprint := fingerprint(r, body)
+
+		err = k.Records.Add(ctx, key, Record{Fingerprint: fingerprint}, cache.TTL(k.Timeout))
+		if cache.IsNotStored(err) {
+			k.duplicate(w, r, key, fingerprint)
+			return
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		before := w.Header().Clone()
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK, limit: k.MaxResponseSize}
+		defer func() {
+			// a panic or a server error leaves the key free for a retry.
+			// The request's ctx may be done by now, the client gone.
+			ctx := context.WithoutCancel(ctx)
+			if p := recover(); p != nil {
+				k.report(r, k.Records.Cache.DeleteContext(ctx, key))
+				panic(p)
+			}
+			if rec.status >= 500 {
+				k.report(r, k.Records.Cache.DeleteContext(ctx, key))
+				return
+			}
+
+			record := Record{Fingerprint: fingerprint, Done: true, Unrecorded: rec.overflow}
+			if !record.Unrecorded {
+				record.Status = rec.status
+				record.Header = recordedHeader(before, w.Header())
+				record.Body = rec.body.Bytes()
+			}
+			err := k.Records.Set(ctx, key, record, cache.TTL(k.TTL))
+			if err != nil && !record.Unrecorded {
+				// still keep the handler from running again
+				k.report(r, err)
+				record = Record{Fingerprint: fingerprint, Done: true, Unrecorded: true}
+				err = k.Records.Set(ctx, key, record, cache.TTL(k.TTL))
+			}
+			k.report(r, err)
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// duplicate answers a request whose key was already claimed.
+func (k *Keys) duplicate(w http.ResponseWriter, r *http.Request, key, fingerprint string) {
+	deadline := time.Now().Add(k.Wait)
+	delay := 50 * time.Millisecond
+	for {
+		record, found, err := k.Records.Get(r.Context(), key)
+		switch {
+		case err != nil:
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		case found && record.Fingerprint != fingerprint:
+			http.Error(w, "Idempotency key reused for a different request", http.StatusUnprocessableEntity)
+			return
+		case found && record.Done && record.Unrecorded:
+			http.Error(w, "A request with this idempotency key was already handled, its response can't be replayed", http.StatusConflict)
+			return
+		case found && record.Done:
+			for name, values := range record.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(record.Status)
+			w.Write(record.Body)
+			return
+		}
+
+		// still running, or it failed and released the key
+		if !time.Now().Add(delay).Before(deadline) {
+			http.Error(w, "A request with this idempotency key is in progress", http.StatusConflict)
+			return
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		if delay < time.Second {
+			delay *= 2
+		}
+	}
+}
+
+func (k *Keys) key(r *http.Request, id string) string {
+	key := k.KeyPrefix
+	if k.Scope != nil {
+		key += k.Scope(r) + ":"
+	}
+	return key + id
+}
+
+func (k *Keys) report(r *http.Request, err error) {
+	if err != nil && k.OnError != nil {
+		k.OnError(r, err)
+	}
+}
+
+// recordedHeader returns the headers of after that weren't in before, as
+// set by the handler, without those never replayed.
+func recordedHeader(before, after http.Header) http.Header {
+	header := http.Header{}
+	for name, values := range after {
+		if !slices.Equal(before[name], values) {
+			header[name] = slices.Clone(values)
+		}
+	}
+	for _, name := range unreplayed {
+		header.Del(name)
+	}
+	return header
+}
+
+// fingerprint identifies a request by its method, path and body.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recorder passes the response through while keeping a copy of up to limit
+// bytes of the body.
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	limit       int64
+	overflow    bool
+	wroteHeader bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.wroteHeader = true
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	if !r.overflow && int64(r.body.Len()+len(b)) > r.limit {
+		r.overflow = true
+		r.body = bytes.Buffer{}
+	}
+	if !r.overflow {
+		r.body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
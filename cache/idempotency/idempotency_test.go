@@ -0,0 +1,231 @@
+package idempotency_test
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iron-io/iron_go/cache"
+	"github.com/iron-io/iron_go/cache/idempotency"
+	"github.com/iron-io/iron_go/config"
+	. "github.com/jeffh/go.bdd"
+)
+
+func TestEverything(t *testing.T) {}
+
+// fakeCache serves the item calls of the IronCache API from memory.
+type fakeCache struct {
+	*httptest.Server
+	sync.Mutex
+	items map[string]interface{}
+}
+
+func newFakeCache() *fakeCache {
+	f := &fakeCache{items: map[string]interface{}{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.Lock()
+		defer f.Unlock()
+		key := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		w.Header().Set("Content-Type", "application/json")
+		value, found := f.items[key]
+		switch r.Method {
+		case "GET":
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"msg": "Key not found."})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"key": key, "value": value})
+		case "PUT":
+			body, _ := io.ReadAll(r.Body)
+			if len(body) > 1<<20 {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"msg": "Value is too large."})
+				return
+			}
+			in := struct {
+				Value interface{}
+				Add   bool
+			}{}
+			json.Unmarshal(body, &in)
+			if in.Add && found {
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(map[string]string{"msg": "Key already exists."})
+				return
+			}
+			f.items[key] = in.Value
+			json.NewEncoder(w).Encode(map[string]string{"msg": "Stored."})
+		case "DELETE":
+			delete(f.items, key)
+			json.NewEncoder(w).Encode(map[string]string{"msg": "Deleted."})
+		}
+	}))
+	return f
+}
+
+func (f *fakeCache) cache() *cache.Cache {
+	host, port, _ := net.SplitHostPort(f.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return &cache.Cache{Name: "idempotency", Settings: config.Settings{
+		Token: "token", ProjectId: "4f2a7c1b9e8d6f3a2b1c0d9e",
+		Host: host, Port: uint16(portNum), Scheme: "http", ApiVersion: "1",
+	}}
+}
+
+func post(h http.Handler, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/payments", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set("Idempotency-Key", key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func init() {
+	defer PrintSpecReport()
+
+	Describe("Keys", func() {
+		f := newFakeCache()
+		keys := idempotency.New(f.cache())
+
+		var mu sync.Mutex
+		runs := 0
+		release := make(chan struct{})
+		payments := keys.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			runs++
+			n := runs
+			mu.Unlock()
+			if r.Header.Get("Idempotency-Key") == "slow" {
+				<-release
+			}
+			if r.Header.Get("Idempotency-Key") == "failing" && n == 4 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Payment", strconv.Itoa(n))
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("paid " + strconv.Itoa(n)))
+		}))
+
+		It("replays the response of a repeated key", func() {
+			first := post(payments, "a", "100")
+			second := post(payments, "a", "100")
+			Expect(runs, ToEqual, 1)
+			Expect(second.Code, ToEqual, http.StatusCreated)
+			Expect(second.Body.String(), ToEqual, first.Body.String())
+			Expect(second.Header().Get("Payment"), ToEqual, "1")
+			Expect(second.Header().Get("Idempotent-Replayed"), ToEqual, "true")
+		})
+
+		It("passes requests without a key through", func() {
+			post(payments, "", "100")
+			post(payments, "", "100")
+			Expect(runs, ToEqual, 3)
+		})
+
+		It("refuses a key reused for another body", func() {
+			Expect(post(payments, "a", "200").Code, ToEqual, http.StatusUnprocessableEntity)
+		})
+
+		It("doesn't record server errors", func() {
+			Expect(post(payments, "failing", "1").Code, ToEqual, http.StatusInternalServerError)
+			Expect(post(payments, "failing", "1").Code, ToEqual, http.StatusCreated)
+		})
+
+		It("answers 409 to duplicates in flight, or waits", func() {
+			done := make(chan int)
+			go func() { done <- post(payments, "slow", "1").Code }()
+			time.Sleep(100 * time.Millisecond)
+			Expect(post(payments, "slow", "1").Code, ToEqual, http.StatusConflict)
+
+			keys.Wait = 5 * time.Second
+			defer func() { keys.Wait = 0 }()
+			waited := make(chan *httptest.ResponseRecorder)
+			go func() { waited <- post(payments, "slow", "1") }()
+			time.Sleep(100 * time.Millisecond)
+			close(release)
+			Expect(<-done, ToEqual, http.StatusCreated)
+			w := <-waited
+			Expect(w.Code, ToEqual, http.StatusCreated)
+			Expect(w.Header().Get("Idempotent-Replayed"), ToEqual, "true")
+		})
+
+		It("replays only the headers the handler set, less cookies", func() {
+			outer := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Request-Id", r.Header.Get("X-Request-Id"))
+				payments.ServeHTTP(w, r)
+			})
+			cookies := keys.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.SetCookie(w, &http.Cookie{Name: "session", Value: "first caller's"})
+				w.Header().Set("Payment", "cookie")
+				w.WriteHeader(http.StatusCreated)
+			}))
+
+			first := httptest.NewRequest("POST", "/payments", strings.NewReader("1"))
+			first.Header.Set("Idempotency-Key", "headers")
+			first.Header.Set("X-Request-Id", "first")
+			outer.ServeHTTP(httptest.NewRecorder(), first)
+			retry := httptest.NewRequest("POST", "/payments", strings.NewReader("1"))
+			retry.Header.Set("Idempotency-Key", "headers")
+			w := httptest.NewRecorder()
+			payments.ServeHTTP(w, retry)
+			Expect(w.Header().Get("X-Request-Id"), ToEqual, "")
+			Expect(w.Header().Get("Payment") == "", ToEqual, false)
+
+			post(cookies, "cookie", "1")
+			replayed := post(cookies, "cookie", "1")
+			Expect(replayed.Header().Get("Payment"), ToEqual, "cookie")
+			Expect(replayed.Header().Get("Set-Cookie"), ToEqual, "")
+		})
+	})
+
+	Describe("Keys with large responses", func() {
+		f := newFakeCache()
+		keys := idempotency.New(f.cache())
+		failures := make(chan error, 10)
+		keys.OnError = func(r *http.Request, err error) { failures <- err }
+
+		var mu sync.Mutex
+		runs := 0
+		reports := keys.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			runs++
+			mu.Unlock()
+			size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(strings.Repeat("x", size)))
+		}))
+		postSize := func(key string, size int) *httptest.ResponseRecorder {
+			r := httptest.NewRequest("POST", "/reports?size="+strconv.Itoa(size), strings.NewReader("1"))
+			r.Header.Set("Idempotency-Key", key)
+			w := httptest.NewRecorder()
+			reports.ServeHTTP(w, r)
+			return w
+		}
+
+		It("doesn't run the handler again when the response is too large to record", func() {
+			Expect(postSize("large", 300<<10).Code, ToEqual, http.StatusCreated)
+			Expect(postSize("large", 300<<10).Code, ToEqual, http.StatusConflict)
+			Expect(runs, ToEqual, 1)
+		})
+
+		It("doesn't run the handler again when recording fails", func() {
+			runs = 0
+			keys.MaxResponseSize = 2 << 20
+			defer func() { keys.MaxResponseSize = 256 << 10 }()
+			Expect(postSize("unstored", 1<<20).Code, ToEqual, http.StatusCreated)
+			Expect(<-failures, ToNotBeNil)
+			Expect(postSize("unstored", 1<<20).Code, ToEqual, http.StatusConflict)
+			Expect(runs, ToEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// ItemMeta is an item along with what IronCache knows about it.
+type ItemMeta struct {
+	Cache string
+	Key   string
+	// StoredKey is the key as sent to IronCache, see Namespace.
+	StoredKey string
+	Value     interface{}
+	Expires   time.Time
+	Flags     int
+}
+
+// CacheInfo describes a cache.
+type CacheInfo struct {
+	ProjectId string
+	Name      string
+	// Size is the number of items. Only Info sets it, listings don't.
+	Size int
+}
+
+// GetItemMeta is like GetMetaContext, with the metadata typed.
+func (c *Cache) GetItemMeta(ctx context.Context, key string) (meta ItemMeta, err error) {
+	out := struct {
+		Cache   string      `json:"cache"`
+		Key     string      `json:"key"`
+		Value   interface{} `json:"value"`
+		Expires time.Time   `json:"expires"`
+		Flags   int         `json:"flags"`
+	}{}
+	if err = c.item(key).ReqContext(ctx, "GET", nil, &out); err != nil {
+		return
+	}
+	value, err := c.unchunk(ctx, key, out.Value)
+	if err != nil {
+		return
+	}
+	return ItemMeta{
+		Cache:     out.Cache,
+		Key:       key,
+		StoredKey: out.Key,
+		Value:     value,
+		Expires:   out.Expires,
+		Flags:     out.Flags,
+	}, nil
+}
+
+// Info returns the cache's description, including its size.
+func (c *Cache) Info(ctx context.Context) (info CacheInfo, err error) {
+	out := struct {
+		ProjectId string `json:"project_id"`
+		Name      string `json:"name"`
+		Size      int    `json:"size"`
+	}{}
+	if err = c.caches(c.Name).ReqContext(ctx, "GET", nil, &out); err != nil {
+		return
+	}
+	if out.Name == "" {
+		out.Name = c.Name
+	}
+	return CacheInfo(out), nil
+}
+
+// Destroy deletes the cache along with all its items.
+func (c *Cache) Destroy(ctx context.Context) (err error) {
+	return c.caches(c.Name).ReqContext(ctx, "DELETE", nil, nil)
+}
+
+// ClearContext is like Clear, but gives up when ctx is done.
+func (c *Cache) ClearContext(ctx context.Context) (err error) {
+	return c.caches(c.Name, "clear").ReqContext(ctx, "POST", nil, nil)
+}
+
+// ListCacheInfo is like ListCaches, returning descriptions of the caches.
+func (c *Cache) ListCacheInfo(ctx context.Context, page, perPage int) (caches []CacheInfo, err error) {
+	out := []struct {
+		ProjectId string `json:"project_id"`
+		Name      string `json:"name"`
+	}{}
+	err = c.caches().
+		QueryAdd("page", "%d", page).
+		QueryAdd("per_page", "%d", perPage).
+		ReqContext(ctx, "GET", nil, &out)
+	if err != nil {
+		return
+	}
+
+	caches = make([]CacheInfo, 0, len(out))
+	for _, item := range out {
+		caches = append(caches, CacheInfo{ProjectId: item.ProjectId, Name: item.Name})
+	}
+	return
+}
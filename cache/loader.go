@@ -0,0 +1,221 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrSoftTTL is returned by a Loader whose SoftTTL isn't shorter than its
+// TTL, so values would never be stale.
+var ErrSoftTTL = errors.New("cache: Loader's SoftTTL has to be shorter than its TTL")
+
+// Loader reads values through a Typed cache, computing and caching the
+// ones that are missing. Concurrent misses for the same key in a process
+// share a single call to Load.
+//
+//	users := cache.NewLoader(cache.NewTyped[User](c, cache.JSON), time.Hour,
+//		func(ctx context.Context, key string) (User, error) {
+//			return db.User(ctx, key)
+//		})
+//	u, err := users.Get(ctx, "user:1")
+type Loader[T any] struct {
+	Typed *Typed[T]
+	// Load computes the value for a missing key.
+	Load func(ctx context.Context, key string) (T, error)
+	// TTL is how long loaded values are cached.
+	TTL time.Duration
+	// SoftTTL, when set, is how long loaded values are fresh. Older values,
+	// up to TTL, are stale: Get returns them right away and refreshes them
+	// in the background, so readers don't wait when a hot key goes stale.
+	// Values are then stored in an envelope with their freshness, which the
+	// Codec has to be able to encode; Proto can't. Values found without the
+	// envelope, as written before SoftTTL was set, are read as missing.
+	SoftTTL time.Duration
+	// Guard, when set, also keeps other processes from loading the same key
+	// at once: the first one to claim the key with an Add loads it while the
+	// others wait for its result, for at most Guard before loading it
+	// themselves.
+	Guard time.Duration
+
+	mu    sync.Mutex
+	calls map[string]*loadCall[T]
+}
+
+// LoadStatus tells where a value returned by a Loader came from.
+type LoadStatus int
+
+const (
+	// Fresh values were cached and within their SoftTTL, if any.
+	Fresh LoadStatus = iota
+	// Stale values were cached past their SoftTTL, a refresh was started.
+	Stale
+	// Loaded values were missing and just computed by Load.
+	Loaded
+)
+
+func (s LoadStatus) String() string {
+	switch s {
+	case Fresh:
+		return "fresh"
+	case Stale:
+		return "stale"
+	case Loaded:
+		return "loaded"
+	}
+	return "unknown"
+}
+
+// softValue is the envelope of values cached with a SoftTTL.
+type softValue[T any] struct {
+	Value      T         `json:"value"`
+	FreshUntil time.Time `json:"fresh_until"`
+}
+
+type loadCall[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+// NewLoader returns a Loader caching the values computed by load for ttl.
+func NewLoader[T any](typed *Typed[T], ttl time.Duration, load func(ctx context.Context, key string) (T, error)) *Loader[T] {
+	return &Loader[T]{Typed: typed, Load: load, TTL: ttl}
+}
+
+// Get returns the cached value of key, loading it if it's missing. Errors
+// reading the cache other than a miss are returned without loading; errors
+// writing a loaded value back are ignored.
+func (l *Loader[T]) Get(ctx context.Context, key string) (value T, err error) {
+	value, _, err = l.GetWithStatus(ctx, key)
+	return value, err
+}
+
+// GetWithStatus is like Get, also telling whether the value was fresh,
+// stale or just loaded.
+func (l *Loader[T]) GetWithStatus(ctx context.Context, key string) (value T, status LoadStatus, err error) {
+	if l.SoftTTL > 0 {
+		ttl := l.TTL
+		if ttl <= 0 {
+			ttl = 7 * 24 * time.Hour // IronCache's default
+		}
+		if l.SoftTTL >= ttl {
+			return value, Fresh, ErrSoftTTL
+		}
+	}
+	value, fresh, found, err := l.read(ctx, key)
+	switch {
+	case err != nil:
+		return value, Fresh, err
+	case found && fresh:
+		return value, Fresh, nil
+	case found:
+		l.start(ctx, key)
+		return value, Stale, nil
+	}
+	value, err = l.load(ctx, key)
+	return value, Loaded, err
+}
+
+// load waits for the shared load of key. The load isn't cancelled when one
+// caller gives up, each caller only stops waiting for it.
+func (l *Loader[T]) load(ctx context.Context, key string) (value T, err error) {
+	call := l.start(ctx, key)
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		return value, ctx.Err()
+	}
+}
+
+// start starts loading key, unless it's already being loaded, coalescing
+// concurrent loads.
+func (l *Loader[T]) start(ctx context.Context, key string) *loadCall[T] {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.calls == nil {
+		l.calls = map[string]*loadCall[T]{}
+	}
+	call, found := l.calls[key]
+	if !found {
+		call = &loadCall[T]{done: make(chan struct{})}
+		l.calls[key] = call
+		go func() {
+			call.value, call.err = l.guardedLoad(context.WithoutCancel(ctx), key)
+			l.mu.Lock()
+			delete(l.calls, key)
+			l.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	return call
+}
+
+// read returns the cached value of key and whether it's still fresh.
+func (l *Loader[T]) read(ctx context.Context, key string) (value T, fresh, found bool, err error) {
+	if l.SoftTTL <= 0 {
+		value, found, err = l.Typed.Get(ctx, key)
+		return value, found, found, err
+	}
+	soft, found, err := l.soft().Get(ctx, key)
+	if _, mismatch := err.(*TypeMismatchError); mismatch || (found && soft.FreshUntil.IsZero()) {
+		// not in an envelope, load it again
+		return value, false, false, nil
+	}
+	return soft.Value, time.Now().Before(soft.FreshUntil), found, err
+}
+
+func (l *Loader[T]) write(ctx context.Context, key string, value T) error {
+	if l.SoftTTL <= 0 {
+		return l.Typed.Set(ctx, key, value, TTL(l.TTL))
+	}
+	soft := softValue[T]{Value: value, FreshUntil: time.Now().Add(l.SoftTTL)}
+	return l.soft().Set(ctx, key, soft, TTL(l.TTL))
+}
+
+func (l *Loader[T]) soft() *Typed[softValue[T]] {
+	return NewTyped[softValue[T]](l.Typed.Cache, l.Typed.Codec)
+}
+
+func (l *Loader[T]) guardedLoad(ctx context.Context, key string) (value T, err error) {
+	if l.Guard > 0 {
+		guard := key + ":loading"
+		err = l.Typed.Cache.PutContext(ctx, guard, &Item{Value: "1", Expiration: l.Guard, Add: true})
+		switch {
+		case err == nil:
+			defer l.Typed.Cache.DeleteContext(ctx, guard)
+		case IsNotStored(err):
+			// someone else is loading it, wait for their result
+			if value, found := l.await(ctx, key); found {
+				return value, nil
+			}
+		default:
+			return value, err
+		}
+	}
+
+	if value, err = l.Load(ctx, key); err != nil {
+		return value, err
+	}
+	l.write(ctx, key, value)
+	return value, nil
+}
+
+// await polls key until it's freshly cached or the guard runs out.
+func (l *Loader[T]) await(ctx context.Context, key string) (value T, found bool) {
+	deadline := time.Now().Add(l.Guard)
+	delay := 50 * time.Millisecond
+	for time.Now().Before(deadline) {
+		time.Sleep(delay)
+		if delay < time.Second {
+			delay *= 2
+		}
+		value, fresh, found, err := l.read(ctx, key)
+		if err == nil && found && fresh {
+			return value, true
+		}
+	}
+	return value, false
+}
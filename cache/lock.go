@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/iron-io/iron_go/api"
+)
+
+// maxExpiration is the longest IronCache keeps an item.
+const maxExpiration = 30 * 24 * time.Hour
+
+var (
+	// ErrLockNotHeld is returned by Unlock when the lock isn't held by the
+	// caller, e.g. because its lease ran out and another owner took it.
+	ErrLockNotHeld = errors.New("cache: lock not held")
+	// ErrLockHeld is returned by Lock and TryLock on a Lock that is
+	// already held by the caller.
+	ErrLockHeld = errors.New("cache: lock already held")
+)
+
+// Lock is a lease based mutex shared by everyone using the same cache and
+// name. The lease is extended in the background while the lock is held, if
+// the process dies the lock frees itself once the lease runs out.
+//
+// IronCache has no compare-and-delete, so Unlock and lease extension check
+// ownership and act in two requests. Keep the TTL comfortably longer than a
+// request to make the window harmless, and pass the fencing token to any
+// resource the lock protects.
+type Lock struct {
+	Cache *Cache
+	Name  string
+	TTL   time.Duration
+
+	mu    sync.Mutex
+	token string
+	fence int64
+	stop  chan struct{}
+	lost  chan struct{}
+}
+
+// NewLock returns an unlocked Lock whose lease lasts ttl, at least a second.
+func NewLock(c *Cache, name string, ttl time.Duration) *Lock {
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return &Lock{Cache: c, Name: name, TTL: ttl}
+}
+
+func (l *Lock) key() string      { return "lock:" + l.Name }
+func (l *Lock) fenceKey() string { return "lock:" + l.Name + ":fence" }
+
+// Lock blocks until the lock is acquired or ctx is done, polling with
+// exponential backoff.
+func (l *Lock) Lock(ctx context.Context) error {
+	delay := 50 * time.Millisecond
+	for {
+		ok, err := l.TryLock(ctx)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil || ok {
+			return err
+		}
+
+		// jitter keeps waiters from polling in lockstep
+		sleep := delay/2 + time.Duration(mrand.Int63n(int64(delay)))
+		select {
+		case <-time.After(sleep):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if delay < time.Second {
+			delay *= 2
+		}
+	}
+}
+
+// TryLock acquires the lock if it's free and reports whether it did.
+func (l *Lock) TryLock(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.token != "" {
+		return false, ErrLockHeld
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return false, err
+	}
+	err = l.Cache.PutContext(ctx, l.key(), &Item{Value: token, Expiration: l.TTL, Add: true})
+	if IsNotStored(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	fence, err := l.nextFence(ctx)
+	if err != nil {
+		l.Cache.DeleteContext(ctx, l.key())
+		return false, err
+	}
+
+	l.token, l.fence = token, fence
+	l.stop, l.lost = make(chan struct{}), make(chan struct{})
+	go l.extend(token, l.stop, l.lost)
+	return true, nil
+}
+
+// Unlock releases the lock. It returns ErrLockNotHeld if the lock isn't
+// held, or was lost to another owner.
+func (l *Lock) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.token == "" {
+		return ErrLockNotHeld
+	}
+	token := l.token
+	close(l.stop)
+	l.token, l.stop = "", nil
+
+	value, err := l.Cache.GetContext(ctx, l.key())
+	if api.IsNotFound(err) || (err == nil && value != token) {
+		return ErrLockNotHeld
+	}
+	if err != nil {
+		return err
+	}
+	return l.Cache.DeleteContext(ctx, l.key())
+}
+
+// Fence returns the fencing token of the current hold. Tokens increase with
+// every acquisition, so a resource can reject writes from an owner whose
+// lease has already passed to someone else.
+//
+// IronCache can't extend an item's expiration on increment, so the counter
+// behind the tokens expires 30 days after it was created. It starts from the
+// current time in milliseconds, so a counter recreated after that still
+// starts above every earlier token, unless a lock was taken more than about
+// 2.6 billion times in those 30 days.
+func (l *Lock) Fence() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fence
+}
+
+// Lost returns a channel that's closed if the current hold is lost before
+// Unlock, because the lease couldn't be extended in time. It returns nil
+// when the lock isn't held.
+func (l *Lock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.token == "" {
+		return nil
+	}
+	return l.lost
+}
+
+// extend renews the lease every third of the TTL until stopped.
+func (l *Lock) extend(token string, stop, lost chan struct{}) {
+	ticker := time.NewTicker(l.TTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), l.TTL/3)
+		value, err := l.Cache.GetContext(ctx, l.key())
+		if err == nil && value == token {
+			err = l.Cache.PutContext(ctx, l.key(), &Item{Value: token, Expiration: l.TTL, Replace: true})
+		} else if err == nil || api.IsNotFound(err) {
+			err = ErrLockNotHeld
+		}
+		cancel()
+
+		// transient errors are retried on the next tick, the lease
+		// outlives two of them.
+		if err == ErrLockNotHeld || IsNotStored(err) {
+			close(lost)
+			return
+		}
+	}
+}
+
+// nextFence increments the fencing counter, creating it from the clock on
+// first use and whenever it expired.
+func (l *Lock) nextFence(ctx context.Context) (int64, error) {
+	return l.Cache.incrementOrCreate(ctx, l.fenceKey(), 1, time.Now().UnixMilli(), maxExpiration)
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
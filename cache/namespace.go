@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxKeyLength is the longest key IronCache accepts. Longer keys are hashed,
+// see StoredKey.
+const MaxKeyLength = 250
+
+// hashedMarker separates the readable start of a hashed key from its hash.
+const hashedMarker = "~"
+
+// Namespace returns a view of c that keeps its keys apart from those of
+// other namespaces sharing the cache, by putting prefix in front of them.
+// Everything built on the view, such as Typed, Counter or Lock, stays
+// within the namespace.
+//
+// Prefix and keys are escaped, so no key of one namespace can name an item
+// of another: "a" and "b:c" give "a:b%3Ac", but "a:b" and "c" give
+// "a%3Ab:c". Keys that are too long once escaped are hashed, and the
+// original key is kept next to them, see OriginalKey. Namespaces nest.
+func Namespace(c *Cache, prefix string) *Cache {
+	view := *c
+	view.namespace = c.namespace + escapeKey(prefix) + ":"
+	return &view
+}
+
+// StoredKey returns the key IronCache stores key under, which differs from
+// key in a Namespace, and for keys longer than MaxKeyLength, which are
+// shortened to their start followed by a hash of the whole key.
+func (c *Cache) StoredKey(key string) string {
+	stored := key
+	if c.namespace != "" {
+		stored = c.namespace + escapeKey(key)
+	}
+	if len(stored) <= MaxKeyLength {
+		return stored
+	}
+	sum := sha256.Sum256([]byte(stored))
+	hash := hex.EncodeToString(sum[:])
+	readable := stored[:MaxKeyLength-len(hashedMarker)-len(hash)]
+	// don't leave half an escape or half a rune behind
+	if i := strings.LastIndexByte(readable, '%'); i >= 0 && i >= len(readable)-2 {
+		readable = readable[:i]
+	}
+	for len(readable) > 0 && !utf8.RuneStart(stored[len(readable)]) {
+		readable = readable[:len(readable)-1]
+	}
+	return readable + hashedMarker + hash
+}
+
+// OriginalKey returns the key a Namespace stored as stored, for debugging.
+// Hashed keys are looked up in the cache, the others are just unescaped.
+func (c *Cache) OriginalKey(ctx context.Context, stored string) (string, error) {
+	if c.hashed(stored) {
+		out := struct {
+			Value string `json:"value"`
+		}{}
+		err := c.caches(c.Name, "items", keyRecord(stored)).ReqContext(ctx, "GET", nil, &out)
+		return out.Value, err
+	}
+	if c.namespace == "" {
+		return stored, nil
+	}
+	return unescapeKey(strings.TrimPrefix(stored, c.namespace)), nil
+}
+
+// recordKey keeps the original of a hashed key next to it, as long as the
+// item. It's only for debugging, so failures are ignored.
+func (c *Cache) recordKey(ctx context.Context, key string, expiration time.Duration) {
+	if stored := c.StoredKey(key); stored != key && c.hashed(stored) {
+		in := struct {
+			Value     string `json:"value"`
+			ExpiresIn int    `json:"expires_in,omitempty"`
+		}{key, expiresIn(expiration)}
+		c.caches(c.Name, "items", keyRecord(stored)).ReqContext(ctx, "PUT", &in, nil)
+	}
+}
+
+func (c *Cache) forgetKey(ctx context.Context, key string) {
+	if stored := c.StoredKey(key); stored != key && c.hashed(stored) {
+		c.caches(c.Name, "items", keyRecord(stored)).ReqContext(ctx, "DELETE", nil, nil)
+	}
+}
+
+// hashed reports whether stored was hashed by StoredKey. Keys of a
+// Namespace are escaped, so only hashing adds hashedMarker to them. Other
+// keys are taken as hashed if they end like a hashed key.
+func (c *Cache) hashed(stored string) bool {
+	if c.namespace != "" {
+		return strings.Contains(stored, hashedMarker)
+	}
+	i := len(stored) - len(hashedMarker) - 2*sha256.Size
+	if i < 0 || !strings.HasPrefix(stored[i:], hashedMarker) {
+		return false
+	}
+	_, err := hex.DecodeString(stored[i+len(hashedMarker):])
+	return err == nil
+}
+
+// keyRecord is where the original of a hashed key is kept. Namespaced keys
+// have hashedMarker escaped so they can't collide with it, but keys stored
+// at the root aren't escaped: one spelled "~key~" followed by the hash of
+// another would share its record.
+func keyRecord(stored string) string {
+	return hashedMarker + "key" + stored[strings.LastIndex(stored, hashedMarker):]
+}
+
+// escapeKey percent-encodes everything but letters, digits and "-._".
+func escapeKey(key string) string {
+	const digits = "0123456789ABCDEF"
+	b := strings.Builder{}
+	for i := 0; i < len(key); i++ {
+		switch ch := key[i]; {
+		case 'a' <= ch && ch <= 'z', 'A' <= ch && ch <= 'Z', '0' <= ch && ch <= '9', ch == '-', ch == '.', ch == '_':
+			b.WriteByte(ch)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(digits[ch>>4])
+			b.WriteByte(digits[ch&15])
+		}
+	}
+	return b.String()
+}
+
+func unescapeKey(escaped string) string {
+	b := strings.Builder{}
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] == '%' && i+2 < len(escaped) {
+			if n, err := hex.DecodeString(escaped[i+1 : i+3]); err == nil {
+				b.WriteByte(n[0])
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(escaped[i])
+	}
+	return b.String()
+}
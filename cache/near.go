@@ -0,0 +1,249 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// defaultExpiration is what IronCache uses when an Item's Expiration is zero.
+const defaultExpiration = 7 * 24 * time.Hour
+
+// NearCache keeps recently used items of a Cache in process memory, so
+// repeated reads of hot keys don't go over the network. Writes made through
+// the NearCache update it, writes made elsewhere are only seen once the
+// local copy expires, so TTL bounds how stale a read can be. A NearCache
+// literal with Cache, Size and TTL set works as well as NewNearCache's.
+type NearCache struct {
+	Cache *Cache
+	// Size is the maximum number of items kept in memory.
+	Size int
+	// TTL is the longest an item is kept in memory. It never outlives the
+	// item's own expiration.
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	stats   NearCacheStats
+}
+
+// NearCacheStats counts what happened to reads and entries of a NearCache.
+type NearCacheStats struct {
+	Hits        int64
+	Misses      int64
+	Evictions   int64 // dropped to stay within Size
+	Expirations int64 // dropped because their TTL passed
+}
+
+type nearEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// NewNearCache returns a NearCache holding up to size items of c for at most
+// ttl each.
+func NewNearCache(c *Cache, size int, ttl time.Duration) *NearCache {
+	return &NearCache{Cache: c, Size: size, TTL: ttl}
+}
+
+// Stats returns a snapshot of the counters.
+func (n *NearCache) Stats() NearCacheStats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.stats
+}
+
+// Len returns the number of items held in memory.
+func (n *NearCache) Len() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.entries)
+}
+
+// Purge drops every item held in memory.
+func (n *NearCache) Purge() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.entries = nil
+	n.lru = nil
+}
+
+// Get gets an item, from memory if possible.
+func (n *NearCache) Get(key string) (value interface{}, err error) {
+	return n.GetContext(context.Background(), key)
+}
+
+// GetContext is like Get, but gives up when ctx is done.
+func (n *NearCache) GetContext(ctx context.Context, key string) (value interface{}, err error) {
+	if value, ok := n.lookup(key); ok {
+		return value, nil
+	}
+
+	meta, err := n.Cache.GetItemMeta(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	expires := meta.Expires
+	if expires.IsZero() {
+		expires = time.Now().Add(defaultExpiration)
+	}
+	n.store(key, meta.Value, expires)
+	return meta.Value, nil
+}
+
+// Put adds an Item to the cache and keeps it in memory.
+func (n *NearCache) Put(key string, item *Item) (err error) {
+	return n.PutContext(context.Background(), key, item)
+}
+
+// PutContext is like Put, but gives up when ctx is done.
+func (n *NearCache) PutContext(ctx context.Context, key string, item *Item) (err error) {
+	if err = n.Cache.PutContext(ctx, key, item); err != nil {
+		// an Add or Replace may have been refused, or the write may have
+		// happened before the error, either way the local copy can't be trusted.
+		n.remove(key)
+		return err
+	}
+
+	// keep what a Get would return, e.g. float64 rather than int
+	value, err := roundTrip(item.Value)
+	if err != nil {
+		n.remove(key)
+		return nil
+	}
+	expiration := item.Expiration
+	if expiration <= 0 {
+		expiration = defaultExpiration
+	}
+	n.store(key, value, time.Now().Add(expiration))
+	return nil
+}
+
+// SetContext is like Cache.SetContext, keeping the value in memory.
+func (n *NearCache) SetContext(ctx context.Context, key string, value interface{}, opts ...ItemOption) (err error) {
+	item, err := newItem(value, opts)
+	if err != nil {
+		return err
+	}
+	return n.PutContext(ctx, key, item)
+}
+
+// Set is like Cache.Set, keeping the value in memory.
+//
+// Deprecated: Use SetContext with the TTL option.
+func (n *NearCache) Set(key string, value interface{}, ttl ...int) (err error) {
+	opts := []ItemOption{}
+	if len(ttl) > 0 {
+		opts = append(opts, TTL(time.Duration(ttl[0])*time.Second))
+	}
+	return n.SetContext(context.Background(), key, value, opts...)
+}
+
+// Delete removes an item from the cache and from memory.
+func (n *NearCache) Delete(key string) (err error) {
+	return n.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext is like Delete, but gives up when ctx is done.
+func (n *NearCache) DeleteContext(ctx context.Context, key string) (err error) {
+	n.remove(key)
+	return n.Cache.DeleteContext(ctx, key)
+}
+
+// Increment increments the item's value, updating the copy in memory.
+func (n *NearCache) Increment(key string, amount int64) (value interface{}, err error) {
+	return n.IncrementContext(context.Background(), key, amount)
+}
+
+// IncrementContext is like Increment, but gives up when ctx is done.
+func (n *NearCache) IncrementContext(ctx context.Context, key string, amount int64) (value interface{}, err error) {
+	value, err = n.Cache.IncrementContext(ctx, key, amount)
+	if err != nil {
+		n.remove(key)
+		return value, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if el, ok := n.entries[key]; ok {
+		el.Value.(*nearEntry).value = value
+	}
+	return value, nil
+}
+
+func (n *NearCache) lookup(key string) (interface{}, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	el, ok := n.entries[key]
+	if !ok {
+		n.stats.Misses++
+		return nil, false
+	}
+	entry := el.Value.(*nearEntry)
+	if time.Now().After(entry.expires) {
+		n.removeElement(el)
+		n.stats.Expirations++
+		n.stats.Misses++
+		return nil, false
+	}
+	n.lru.MoveToFront(el)
+	n.stats.Hits++
+	return entry.value, true
+}
+
+// store keeps value until expires or the NearCache's TTL, whichever is first.
+func (n *NearCache) store(key string, value interface{}, expires time.Time) {
+	if n.Size <= 0 || n.TTL <= 0 {
+		return
+	}
+	if local := time.Now().Add(n.TTL); local.Before(expires) {
+		expires = local
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.entries == nil {
+		n.entries = map[string]*list.Element{}
+		n.lru = list.New()
+	}
+	if el, ok := n.entries[key]; ok {
+		entry := el.Value.(*nearEntry)
+		entry.value, entry.expires = value, expires
+		n.lru.MoveToFront(el)
+		return
+	}
+	n.entries[key] = n.lru.PushFront(&nearEntry{key: key, value: value, expires: expires})
+	for n.lru.Len() > n.Size {
+		n.removeElement(n.lru.Back())
+		n.stats.Evictions++
+	}
+}
+
+func (n *NearCache) remove(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if el, ok := n.entries[key]; ok {
+		n.removeElement(el)
+	}
+}
+
+func (n *NearCache) removeElement(el *list.Element) {
+	n.lru.Remove(el)
+	delete(n.entries, el.Value.(*nearEntry).key)
+}
+
+func roundTrip(value interface{}) (out interface{}, err error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &out)
+	return out, err
+}
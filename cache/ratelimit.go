@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/iron-io/iron_go/api"
+)
+
+// RateAlgorithm is how a RateLimiter counts requests.
+type RateAlgorithm int
+
+const (
+	// FixedWindow counts requests per window, e.g. per clock minute. It is
+	// the cheapest but lets up to twice the limit through around a window
+	// boundary.
+	FixedWindow RateAlgorithm = iota
+	// SlidingWindow approximates a window ending now by adding the count of
+	// the previous window, weighted by how much of it overlaps, to the
+	// current one. It costs one more read per request.
+	SlidingWindow
+)
+
+// RateLimiter limits how often a key, such as a client's address, may do
+// something, with counts shared through a Cache so every process sees the
+// same limit. Counter items expire on their own shortly after their window.
+type RateLimiter struct {
+	Cache *Cache
+	// Limit requests are allowed per Window, which is at least a second.
+	Limit     int64
+	Window    time.Duration
+	Algorithm RateAlgorithm
+	// Prefix is put in front of keys to get counter keys.
+	Prefix string
+}
+
+// RateLimit is the outcome of RateLimiter.Allow.
+type RateLimit struct {
+	Allowed   bool
+	Limit     int64
+	Remaining int64
+	// Reset is when the current window ends and the quota starts to free up.
+	Reset time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing limit requests per window.
+func NewRateLimiter(c *Cache, limit int64, window time.Duration, algorithm RateAlgorithm) *RateLimiter {
+	return &RateLimiter{Cache: c, Limit: limit, Window: window, Algorithm: algorithm, Prefix: "ratelimit:"}
+}
+
+// Allow counts a request for key and reports whether it is within the limit.
+// Refused requests count too, so clients that keep retrying stay refused.
+func (l *RateLimiter) Allow(ctx context.Context, key string) (RateLimit, error) {
+	window := l.Window
+	if window < time.Second {
+		window = time.Second
+	}
+	now := time.Now()
+	start := now.Truncate(window)
+	reset := start.Add(window)
+
+	// keep the counter for the next window too, the sliding window reads it
+	// back as the previous one.
+	count, err := l.Cache.incrementOrCreate(ctx, l.windowKey(key, start), 1, 0, reset.Sub(now)+window+time.Second)
+	if err != nil {
+		return RateLimit{}, err
+	}
+
+	if l.Algorithm == SlidingWindow {
+		previous, err := l.Cache.GetContext(ctx, l.windowKey(key, start.Add(-window)))
+		if err != nil && !api.IsNotFound(err) {
+			return RateLimit{}, err
+		}
+		if n, ok := previous.(float64); ok {
+			overlap := 1 - float64(now.Sub(start))/float64(window)
+			count += int64(math.Floor(n * overlap))
+		}
+	}
+
+	remaining := l.Limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	return RateLimit{Allowed: count <= l.Limit, Limit: l.Limit, Remaining: remaining, Reset: reset}, nil
+}
+
+func (l *RateLimiter) windowKey(key string, start time.Time) string {
+	return l.Prefix + key + ":" + strconv.FormatInt(start.Unix(), 10)
+}
+
+// Middleware limits requests by the key keyFunc returns for them, replying
+// 429 Too Many Requests over the limit. Responses carry RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers, plus Retry-After when
+// refused. Requests are let through when the cache can't be reached, so an
+// outage of the cache doesn't take the service down with it.
+func (l *RateLimiter) Middleware(keyFunc func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit, err := l.Allow(r.Context(), keyFunc(r))
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			reset := int64(math.Ceil(time.Until(limit.Reset).Seconds()))
+			if reset < 0 {
+				reset = 0
+			}
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.FormatInt(limit.Limit, 10))
+			h.Set("RateLimit-Remaining", strconv.FormatInt(limit.Remaining, 10))
+			h.Set("RateLimit-Reset", strconv.FormatInt(reset, 10))
+			if !limit.Allowed {
+				h.Set("Retry-After", strconv.FormatInt(reset, 10))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package cache_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iron-io/iron_go/cache"
+	"github.com/iron-io/iron_go/config"
+)
+
+// fakeServer is an in-memory stand-in for the IronCache API, good enough to
+// exercise the client without credentials.
+type fakeServer struct {
+	*httptest.Server
+	sync.Mutex
+	caches   map[string]map[string]*fakeItem
+	requests int
+}
+
+type fakeItem struct {
+	value   interface{}
+	expires time.Time
+}
+
+func newFakeServer() *fakeServer {
+	s := &fakeServer{caches: map[string]map[string]*fakeItem{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// cache returns a Cache talking to the fake server.
+func (s *fakeServer) cache(name string) *cache.Cache {
+	host, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return &cache.Cache{
+		Name: name,
+		Settings: config.Settings{
+			Token:      "token",
+			ProjectId:  "4f2a7c1b9e8d6f3a2b1c0d9e",
+			Host:       host,
+			Port:       uint16(portNum),
+			Scheme:     "http",
+			ApiVersion: "1",
+		},
+	}
+}
+
+// keys returns the keys stored in a cache, sorted.
+func (s *fakeServer) keys(cacheName string) []string {
+	s.Lock()
+	defer s.Unlock()
+	keys := []string{}
+	for key := range s.caches[cacheName] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *fakeServer) reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *fakeServer) msg(w http.ResponseWriter, status int, msg string) {
+	s.reply(w, status, map[string]string{"msg": msg})
+}
+
+func (s *fakeServer) item(cacheName, key string) *fakeItem {
+	item := s.caches[cacheName][key]
+	if item != nil && !item.expires.IsZero() && time.Now().After(item.expires) {
+		delete(s.caches[cacheName], key)
+		return nil
+	}
+	return item
+}
+
+func (s *fakeServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	s.requests++
+
+	// /1/projects/{id}/caches/{cache}/items/{key}[/increment]
+	parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	for i := range parts {
+		parts[i], _ = url.PathUnescape(parts[i])
+	}
+	if len(parts) < 4 || parts[3] != "caches" {
+		s.msg(w, http.StatusNotFound, "Not found")
+		return
+	}
+	parts = parts[4:]
+
+	switch {
+	case len(parts) == 0 && r.Method == "GET":
+		out := []map[string]string{}
+		for name := range s.caches {
+			out = append(out, map[string]string{"project_id": "4f2a7c1b9e8d6f3a2b1c0d9e", "name": name})
+		}
+		s.reply(w, http.StatusOK, out)
+	case len(parts) == 1 && r.Method == "GET":
+		items, found := s.caches[parts[0]]
+		if !found {
+			s.msg(w, http.StatusNotFound, "Cache not found")
+			return
+		}
+		s.reply(w, http.StatusOK, map[string]interface{}{"name": parts[0], "size": len(items)})
+	case len(parts) == 1 && r.Method == "DELETE":
+		delete(s.caches, parts[0])
+		s.msg(w, http.StatusOK, "Deleted")
+	case len(parts) == 2 && parts[1] == "clear" && r.Method == "POST":
+		s.caches[parts[0]] = map[string]*fakeItem{}
+		s.msg(w, http.StatusOK, "Cleared")
+	case len(parts) >= 3 && parts[1] == "items":
+		s.serveItem(w, r, parts[0], parts[2], parts[3:])
+	default:
+		s.msg(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (s *fakeServer) serveItem(w http.ResponseWriter, r *http.Request, cacheName, key string, rest []string) {
+	if s.caches[cacheName] == nil {
+		s.caches[cacheName] = map[string]*fakeItem{}
+	}
+	item := s.item(cacheName, key)
+
+	switch {
+	case len(rest) == 1 && rest[0] == "increment" && r.Method == "POST":
+		in := struct{ Amount float64 }{}
+		json.NewDecoder(r.Body).Decode(&in)
+		if item == nil {
+			s.msg(w, http.StatusNotFound, "Key not found.")
+			return
+		}
+		n, ok := item.value.(float64)
+		if !ok {
+			s.msg(w, http.StatusBadRequest, "Cannot increment or decrement non-numeric value")
+			return
+		}
+		item.value = n + in.Amount
+		s.reply(w, http.StatusOK, map[string]interface{}{"msg": "Added", "value": item.value})
+	case len(rest) > 0:
+		s.msg(w, http.StatusNotFound, "Not found")
+	case r.Method == "GET":
+		if item == nil {
+			s.msg(w, http.StatusNotFound, "Key not found.")
+			return
+		}
+		expires := "9999-01-01T00:00:00Z"
+		if !item.expires.IsZero() {
+			expires = item.expires.UTC().Format(time.RFC3339)
+		}
+		s.reply(w, http.StatusOK, map[string]interface{}{
+			"cache": cacheName, "key": key, "value": item.value, "expires": expires, "flags": 0,
+		})
+	case r.Method == "PUT":
+		in := struct {
+			Value     interface{} `json:"value"`
+			ExpiresIn int         `json:"expires_in"`
+			Replace   bool        `json:"replace"`
+			Add       bool        `json:"add"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			s.msg(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if in.ExpiresIn > 30*24*3600 {
+			s.msg(w, http.StatusBadRequest, "expires_in is too long")
+			return
+		}
+		if in.Add && item != nil {
+			s.msg(w, http.StatusConflict, "Key already exists.")
+			return
+		}
+		if in.Replace && item == nil {
+			s.msg(w, http.StatusNotFound, "Key not found.")
+			return
+		}
+		stored := &fakeItem{value: in.Value}
+		if in.ExpiresIn > 0 {
+			stored.expires = time.Now().Add(time.Duration(in.ExpiresIn) * time.Second)
+		}
+		s.caches[cacheName][key] = stored
+		s.msg(w, http.StatusOK, "Stored.")
+	case r.Method == "DELETE":
+		if item == nil {
+			s.msg(w, http.StatusNotFound, "Key not found.")
+			return
+		}
+		delete(s.caches[cacheName], key)
+		s.msg(w, http.StatusOK, "Deleted.")
+	default:
+		s.msg(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
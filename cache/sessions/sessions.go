@@ -0,0 +1,326 @@
+// Package sessions keeps net/http sessions in IronCache, so any instance
+// behind a load balancer can serve any request.
+//
+//	key, err := base64.StdEncoding.DecodeString(os.Getenv("SESSION_KEY"))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	store, err := sessions.NewCacheStore(c, key)
+//	if err != nil {
+//		log.Fatal(err) // SESSION_KEY is unset or too short
+//	}
+//	http.ListenAndServe(":8080", sessions.Middleware(store)(mux))
+//
+// and in a handler:
+//
+//	s := sessions.FromContext(r.Context())
+//	s.Values["user"] = userID
+//	s.RenewID() // after logging in
+package sessions
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/iron-io/iron_go/cache"
+)
+
+// MinKeySize is the shortest key accepted for signing cookies.
+const MinKeySize = 16
+
+var (
+	// ErrNoSession is returned by Get when the request has no valid session.
+	ErrNoSession = errors.New("sessions: no session")
+	// ErrShortKey is returned for signing keys shorter than MinKeySize.
+	ErrShortKey = errors.New("sessions: signing keys must be at least 16 bytes")
+)
+
+// Session is the state kept for one client between requests.
+type Session struct {
+	// ID is the session's key in the cache, also sent in the cookie.
+	ID     string
+	Values map[string]interface{}
+	// IsNew is true for sessions created by New that haven't been saved.
+	IsNew bool
+
+	oldID     string
+	destroyed bool
+}
+
+// RenewID gives the session a new ID when it is next saved, deleting the
+// old one. Call it whenever the session's privileges change, as on login or
+// logout, so an ID leaked before can't be used to ride the new privileges.
+func (s *Session) RenewID() error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	if s.oldID == "" && !s.IsNew {
+		s.oldID = s.ID
+	}
+	s.ID = id
+	return nil
+}
+
+// Store loads and saves sessions of requests.
+type Store interface {
+	// Get returns the session of r, ErrNoSession if it has none.
+	Get(r *http.Request) (*Session, error)
+	// New returns an empty session for r.
+	New(r *http.Request) (*Session, error)
+	// Save stores s and sends its cookie through w.
+	Save(w http.ResponseWriter, r *http.Request, s *Session) error
+	// Destroy deletes s and its cookie.
+	Destroy(w http.ResponseWriter, r *http.Request, s *Session) error
+}
+
+// CacheStore is a Store keeping sessions in a Cache. The cookie only holds
+// the session's ID, signed so clients can't forge one.
+type CacheStore struct {
+	Sessions *cache.Typed[map[string]interface{}]
+	// Keys sign cookies. The first one signs, any of them verifies, so a
+	// new key can be put in front while cookies signed by the old one are
+	// still in use. Keys shorter than MinKeySize are ignored.
+	Keys [][]byte
+	// Expiration is how long a session lasts without requests. Every save
+	// extends it again.
+	Expiration time.Duration
+	// KeyPrefix is put in front of session IDs to get cache keys.
+	KeyPrefix string
+
+	// Cookie attributes.
+	CookieName string
+	Path       string
+	Domain     string
+	Secure     bool
+	SameSite   http.SameSite
+}
+
+// NewCacheStore returns a CacheStore keeping sessions in c as JSON for a
+// day, signing cookies with key. It fails with ErrShortKey if key is shorter
+// than MinKeySize.
+func NewCacheStore(c *cache.Cache, key []byte) (*CacheStore, error) {
+	if len(key) < MinKeySize {
+		return nil, ErrShortKey
+	}
+	return &CacheStore{
+		Sessions:   cache.NewTyped[map[string]interface{}](c, cache.JSON),
+		Keys:       [][]byte{key},
+		Expiration: 24 * time.Hour,
+		KeyPrefix:  "session:",
+		CookieName: "session",
+		Path:       "/",
+		Secure:     true,
+		SameSite:   http.SameSiteLaxMode,
+	}, nil
+}
+
+func (s *CacheStore) Get(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(s.CookieName)
+	if err != nil {
+		return nil, ErrNoSession
+	}
+	id, ok := s.verify(cookie.Value)
+	if !ok {
+		return nil, ErrNoSession
+	}
+
+	values, found, err := s.Sessions.Get(r.Context(), s.KeyPrefix+id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNoSession
+	}
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	return &Session{ID: id, Values: values}, nil
+}
+
+func (s *CacheStore) New(r *http.Request) (*Session, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	return &Session{ID: id, Values: map[string]interface{}{}, IsNew: true}, nil
+}
+
+// Save stores the session for Expiration, sending the cookie with the same
+// lifetime. A destroyed session isn't saved again.
+func (s *CacheStore) Save(w http.ResponseWriter, r *http.Request, session *Session) error {
+	if session.destroyed {
+		return nil
+	}
+	value, err := s.sign(session.ID)
+	if err != nil {
+		return err
+	}
+	err = s.Sessions.Set(r.Context(), s.KeyPrefix+session.ID, session.Values, cache.TTL(s.Expiration))
+	if err != nil {
+		return err
+	}
+	if session.oldID != "" {
+		// best effort, the old session expires anyway
+		s.Sessions.Cache.DeleteContext(r.Context(), s.KeyPrefix+session.oldID)
+		session.oldID = ""
+	}
+	session.IsNew = false
+
+	http.SetCookie(w, s.cookie(value, int(s.Expiration.Seconds())))
+	return nil
+}
+
+func (s *CacheStore) Destroy(w http.ResponseWriter, r *http.Request, session *Session) error {
+	session.destroyed = true
+	http.SetCookie(w, s.cookie("", -1))
+	if session.IsNew {
+		return nil
+	}
+	err := s.Sessions.Cache.DeleteContext(r.Context(), s.KeyPrefix+session.ID)
+	if session.oldID != "" {
+		s.Sessions.Cache.DeleteContext(r.Context(), s.KeyPrefix+session.oldID)
+	}
+	return err
+}
+
+func (s *CacheStore) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     s.CookieName,
+		Value:    value,
+		Path:     s.Path,
+		Domain:   s.Domain,
+		MaxAge:   maxAge,
+		Secure:   s.Secure,
+		HttpOnly: true,
+		SameSite: s.SameSite,
+	}
+}
+
+// sign returns id followed by its signature with the first key.
+func (s *CacheStore) sign(id string) (string, error) {
+	if len(s.Keys) == 0 || len(s.Keys[0]) < MinKeySize {
+		return "", ErrShortKey
+	}
+	return id + "." + base64.RawURLEncoding.EncodeToString(s.mac(s.Keys[0], id)), nil
+}
+
+func (s *CacheStore) verify(value string) (id string, ok bool) {
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return "", false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(value[i+1:])
+	if err != nil {
+		return "", false
+	}
+	id = value[:i]
+	for _, key := range s.Keys {
+		if len(key) >= MinKeySize && hmac.Equal(sig, s.mac(key, id)) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// mac binds the signature to the cookie name, so a value signed for another
+// cookie with the same key isn't accepted.
+func (s *CacheStore) mac(key []byte, id string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s.CookieName + "=" + id))
+	return h.Sum(nil)
+}
+
+func newID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+type contextKey struct{}
+
+// FromContext returns the session Middleware loaded for the request, nil
+// outside of it.
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(contextKey{}).(*Session)
+	return s
+}
+
+// Middleware loads the request's session, or starts a new one, for handlers
+// to get with FromContext, and saves it just before the response is written.
+// New sessions are only saved once they hold values, so requests that don't
+// use theirs cost neither a cache write nor a cookie. If the session can't be
+// loaded or saved the response is a 500 instead.
+func Middleware(store Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session, err := store.Get(r)
+			if err == ErrNoSession {
+				session, err = store.New(r)
+			}
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			r = r.WithContext(context.WithValue(r.Context(), contextKey{}, session))
+			sw := &sessionWriter{ResponseWriter: w, save: func() error {
+				if session.IsNew && len(session.Values) == 0 {
+					return nil
+				}
+				return store.Save(w, r, session)
+			}}
+			next.ServeHTTP(sw, r)
+			sw.commit()
+		})
+	}
+}
+
+// sessionWriter saves the session before the headers go out, as the
+// cookie is one of them.
+type sessionWriter struct {
+	http.ResponseWriter
+	save      func() error
+	committed bool
+	failed    bool
+}
+
+func (w *sessionWriter) commit() {
+	if w.committed {
+		return
+	}
+	w.committed = true
+	if err := w.save(); err != nil {
+		w.failed = true
+		http.Error(w.ResponseWriter, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+func (w *sessionWriter) WriteHeader(status int) {
+	w.commit()
+	if !w.failed {
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	w.commit()
+	if w.failed {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
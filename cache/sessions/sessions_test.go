@@ -0,0 +1,198 @@
+package sessions_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/iron-io/iron_go/cache"
+	"github.com/iron-io/iron_go/cache/sessions"
+	"github.com/iron-io/iron_go/config"
+	. "github.com/jeffh/go.bdd"
+)
+
+func TestEverything(t *testing.T) {}
+
+// fakeCache serves the item calls of the IronCache API from memory.
+type fakeCache struct {
+	*httptest.Server
+	sync.Mutex
+	items map[string]interface{}
+}
+
+func newFakeCache() *fakeCache {
+	f := &fakeCache{items: map[string]interface{}{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.Lock()
+		defer f.Unlock()
+		key := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case "GET":
+			value, found := f.items[key]
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"msg": "Key not found."})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"key": key, "value": value})
+		case "PUT":
+			in := struct{ Value interface{} }{}
+			json.NewDecoder(r.Body).Decode(&in)
+			f.items[key] = in.Value
+			json.NewEncoder(w).Encode(map[string]string{"msg": "Stored."})
+		case "DELETE":
+			delete(f.items, key)
+			json.NewEncoder(w).Encode(map[string]string{"msg": "Deleted."})
+		}
+	}))
+	return f
+}
+
+func (f *fakeCache) cache() *cache.Cache {
+	host, port, _ := net.SplitHostPort(f.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return &cache.Cache{Name: "sessions", Settings: config.Settings{
+		Token: "token", ProjectId: "4f2a7c1b9e8d6f3a2b1c0d9e",
+		Host: host, Port: uint16(portNum), Scheme: "http", ApiVersion: "1",
+	}}
+}
+
+func (f *fakeCache) has(key string) bool {
+	f.Lock()
+	defer f.Unlock()
+	_, found := f.items[key]
+	return found
+}
+
+func (f *fakeCache) size() int {
+	f.Lock()
+	defer f.Unlock()
+	return len(f.items)
+}
+
+// serve runs one request through the middleware, returning the response.
+func serve(h http.Handler, cookie *http.Cookie) *http.Response {
+	r := httptest.NewRequest("GET", "/", nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Result()
+}
+
+func sessionCookie(res *http.Response) *http.Cookie {
+	for _, c := range res.Cookies() {
+		if c.Name == "session" {
+			return c
+		}
+	}
+	return nil
+}
+
+func init() {
+	defer PrintSpecReport()
+
+	Describe("CacheStore", func() {
+		f := newFakeCache()
+		store, _ := sessions.NewCacheStore(f.cache(), []byte("0123456789abcdef"))
+
+		visits := sessions.Middleware(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s := sessions.FromContext(r.Context())
+			n, _ := s.Values["visits"].(float64)
+			s.Values["visits"] = n + 1
+			switch r.URL.Query().Get("do") {
+			case "login":
+				s.RenewID()
+			case "logout":
+				store.Destroy(w, r, s)
+			}
+			w.Write([]byte(strconv.Itoa(int(n + 1))))
+		}))
+
+		It("sends a signed, HttpOnly, SameSite cookie", func() {
+			cookie := sessionCookie(serve(visits, nil))
+			Expect(cookie, ToNotBeNil)
+			Expect(cookie.HttpOnly, ToEqual, true)
+			Expect(cookie.Secure, ToEqual, true)
+			Expect(cookie.SameSite, ToEqual, http.SameSiteLaxMode)
+			Expect(cookie.MaxAge, ToEqual, 24*60*60)
+			Expect(strings.Contains(cookie.Value, "."), ToEqual, true)
+		})
+
+		It("keeps values between requests", func() {
+			cookie := sessionCookie(serve(visits, nil))
+			res := serve(visits, cookie)
+			body := make([]byte, 1)
+			res.Body.Read(body)
+			Expect(string(body), ToEqual, "2")
+		})
+
+		It("starts over when the cookie is forged", func() {
+			cookie := sessionCookie(serve(visits, nil))
+			cookie.Value = strings.Replace(cookie.Value, ".", "x.", 1)
+			next := sessionCookie(serve(visits, cookie))
+			Expect(next.Value == cookie.Value, ToEqual, false)
+		})
+
+		It("accepts cookies signed with an older key", func() {
+			cookie := sessionCookie(serve(visits, nil))
+			store.Keys = [][]byte{[]byte("fedcba9876543210"), []byte("0123456789abcdef")}
+			defer func() { store.Keys = [][]byte{[]byte("0123456789abcdef")} }()
+			next := sessionCookie(serve(visits, cookie))
+			Expect(strings.SplitN(next.Value, ".", 2)[0], ToEqual, strings.SplitN(cookie.Value, ".", 2)[0])
+		})
+
+		It("rotates the ID on login", func() {
+			cookie := sessionCookie(serve(visits, nil))
+			oldID := strings.SplitN(cookie.Value, ".", 2)[0]
+			r := httptest.NewRequest("GET", "/?do=login", nil)
+			r.AddCookie(cookie)
+			w := httptest.NewRecorder()
+			visits.ServeHTTP(w, r)
+			newID := strings.SplitN(sessionCookie(w.Result()).Value, ".", 2)[0]
+			Expect(newID == oldID, ToEqual, false)
+			Expect(f.has("session:"+oldID), ToEqual, false)
+			Expect(f.has("session:"+newID), ToEqual, true)
+		})
+
+		It("doesn't save sessions that were never used", func() {
+			untouched := sessions.Middleware(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("hello"))
+			}))
+			before := f.size()
+			Expect(sessionCookie(serve(untouched, nil)), ToBeNil)
+			Expect(f.size(), ToEqual, before)
+		})
+
+		It("refuses missing or short signing keys", func() {
+			_, err := sessions.NewCacheStore(f.cache(), nil)
+			Expect(err, ToEqual, sessions.ErrShortKey)
+			_, err = sessions.NewCacheStore(f.cache(), []byte("secret"))
+			Expect(err, ToEqual, sessions.ErrShortKey)
+
+			keyless := &sessions.CacheStore{Sessions: store.Sessions, CookieName: "session"}
+			res := serve(sessions.Middleware(keyless)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sessions.FromContext(r.Context()).Values["user"] = "mallory"
+			})), nil)
+			Expect(res.StatusCode, ToEqual, http.StatusInternalServerError)
+		})
+
+		It("destroys sessions", func() {
+			cookie := sessionCookie(serve(visits, nil))
+			id := strings.SplitN(cookie.Value, ".", 2)[0]
+			r := httptest.NewRequest("GET", "/?do=logout", nil)
+			r.AddCookie(cookie)
+			w := httptest.NewRecorder()
+			visits.ServeHTTP(w, r)
+			Expect(sessionCookie(w.Result()).MaxAge, ToEqual, -1)
+			Expect(f.has("session:"+id), ToEqual, false)
+		})
+	})
+}
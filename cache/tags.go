@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iron-io/iron_go/api"
+)
+
+// Tags groups items so a whole group can be invalidated at once, although
+// IronCache can't list keys. Each tag has a version, and items are stored
+// under keys that embed the versions of their tags; invalidating a tag
+// bumps its version, so the old keys are never read again and expire.
+//
+//	tags := cache.NewTags(c)
+//	key, err := tags.Key(ctx, "report:42", "tenant:7")
+//	reports.Set(ctx, key, report)
+//	...
+//	tags.InvalidateTag(ctx, "tenant:7")
+type Tags struct {
+	Cache *Cache
+	// Prefix is put in front of tags to get their version keys.
+	Prefix string
+}
+
+// tagEscaper escapes the separators of keys built by Tags.Key, so different
+// keys and tags can't run together into the same key.
+var tagEscaper = strings.NewReplacer("%", "%25", "@", "%40", ",", "%2C", "=", "%3D")
+
+// NewTags returns Tags keeping versions in c.
+func NewTags(c *Cache) *Tags {
+	return &Tags{Cache: c, Prefix: "tag:"}
+}
+
+// Key returns the key to store key under, given the current versions of its
+// tags. The key changes whenever one of the tags is invalidated. Any "@",
+// ",", "=" and "%" in key and tags are percent-escaped.
+func (t *Tags) Key(ctx context.Context, key string, tags ...string) (string, error) {
+	versions := make([]int64, len(tags))
+	errs := make([]error, len(tags))
+	t.Cache.batch(len(tags), func(i int) {
+		versions[i], errs[i] = t.Version(ctx, tags[i])
+	})
+
+	b := strings.Builder{}
+	b.WriteString(tagEscaper.Replace(key))
+	for i, tag := range tags {
+		if errs[i] != nil {
+			return "", errs[i]
+		}
+		if i == 0 {
+			b.WriteString("@")
+		} else {
+			b.WriteString(",")
+		}
+		b.WriteString(tagEscaper.Replace(tag) + "=" + strconv.FormatInt(versions[i], 10))
+	}
+	return b.String(), nil
+}
+
+// Version returns the current version of tag.
+//
+// Versions start at the current time in milliseconds rather than 0, so a
+// version item that expired or was evicted comes back as a version no key
+// was ever built with. Milliseconds, unlike nanoseconds, survive being
+// decoded as a float64.
+func (t *Tags) Version(ctx context.Context, tag string) (int64, error) {
+	value, err := t.Cache.GetContext(ctx, t.Prefix+tag)
+	if api.IsNotFound(err) {
+		value, err = t.create(ctx, tag)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return integer(t.Prefix+tag, value)
+}
+
+// InvalidateTag bumps the version of tag, so keys built for it before are
+// no longer used.
+func (t *Tags) InvalidateTag(ctx context.Context, tag string) error {
+	_, err := t.Cache.IncrementContext(ctx, t.Prefix+tag, 1)
+	if api.IsNotFound(err) {
+		_, err = t.create(ctx, tag)
+	}
+	return err
+}
+
+// create starts tag's version, returning whichever version won if another
+// process created it first.
+func (t *Tags) create(ctx context.Context, tag string) (interface{}, error) {
+	err := t.Cache.PutContext(ctx, t.Prefix+tag, &Item{
+		Value:      time.Now().UnixMilli(),
+		Expiration: maxExpiration,
+		Add:        true,
+	})
+	if err != nil && !IsNotStored(err) {
+		return nil, err
+	}
+	return t.Cache.GetContext(ctx, t.Prefix+tag)
+}
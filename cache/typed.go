@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/iron-io/iron_go/api"
+)
+
+// Typed stores values of type T in a Cache, encoded with a Codec.
+//
+//	users := cache.NewTyped[User](c, cache.JSON)
+//	u, found, err := users.Get(ctx, "user:1")
+type Typed[T any] struct {
+	Cache *Cache
+	Codec Codec
+}
+
+// NewTyped returns a Typed storing T values in c using codec.
+func NewTyped[T any](c *Cache, codec Codec) *Typed[T] {
+	return &Typed[T]{Cache: c, Codec: codec}
+}
+
+// Get returns the value stored at key. A missing key is not an error, found
+// is false instead. A value that can't be decoded into T is reported as a
+// *TypeMismatchError, one that fails decryption as a *TamperError.
+func (t *Typed[T]) Get(ctx context.Context, key string) (value T, found bool, err error) {
+	raw, err := t.Cache.GetContext(ctx, key)
+	if api.IsNotFound(err) {
+		return value, false, nil
+	}
+	if err != nil {
+		return value, false, err
+	}
+
+	if err = t.Codec.decode(t.Cache, key, raw, &value); err != nil {
+		return value, false, err
+	}
+	return value, true, nil
+}
+
+// Set stores value at key, see Cache.SetContext for the options.
+func (t *Typed[T]) Set(ctx context.Context, key string, value T, opts ...ItemOption) error {
+	bytes, err := t.Codec.encode(t.Cache, key, value)
+	if err != nil {
+		return err
+	}
+	return t.Cache.SetContext(ctx, key, string(bytes), opts...)
+}
+
+// Add stores value at key only if the key isn't currently cached.
+func (t *Typed[T]) Add(ctx context.Context, key string, value T, opts ...ItemOption) error {
+	return t.Set(ctx, key, value, append(opts, IfAbsent())...)
+}
+
+// Replace stores value at key only if the key is currently cached.
+func (t *Typed[T]) Replace(ctx context.Context, key string, value T, opts ...ItemOption) error {
+	return t.Set(ctx, key, value, append(opts, IfPresent())...)
+}
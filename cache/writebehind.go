@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrWriteBehindClosed is returned by writes to a closed WriteBehind.
+var ErrWriteBehindClosed = errors.New("cache: WriteBehind is closed")
+
+// PendingWritesError is returned by WriteBehind.Close when ctx is done
+// before every buffered write was made.
+type PendingWritesError struct {
+	// Pending is the number of keys that weren't written.
+	Pending int
+	Err     error
+}
+
+func (e *PendingWritesError) Error() string {
+	return fmt.Sprintf("cache: WriteBehind closed with %d writes not made: %v", e.Pending, e.Err)
+}
+
+func (e *PendingWritesError) Unwrap() error {
+	return e.Err
+}
+
+// WriteBehind buffers writes to a Cache in memory and writes them in the
+// background, for state where only the last value of a key matters: a key
+// written again before it was flushed is only written once, with its last
+// value. Writes are flushed every Interval, or as soon as MaxPending keys are
+// waiting, at most BatchConcurrency of the Cache at once.
+//
+//	w := cache.NewWriteBehind(c, time.Second, 1000)
+//	defer w.Close(ctx)
+//	w.Set("progress:"+job, n)
+type WriteBehind struct {
+	Cache *Cache
+	// OnError is called with the writes that failed in a background flush.
+	// Set it before the first write.
+	OnError func(key string, item *Item, err error)
+
+	interval   time.Duration
+	maxPending int
+
+	mu      sync.Mutex
+	pending map[string]*Item
+	started bool
+	closed  bool
+	// flushMu keeps flushes in order, so an older value of a key can't be
+	// written after a newer one.
+	flushMu sync.Mutex
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewWriteBehind returns a WriteBehind writing to c every interval, or
+// sooner once maxPending keys are waiting. An interval of zero only flushes
+// once maxPending keys are waiting, a maxPending of zero means no limit.
+// With both zero, writes are only made by Flush and Close.
+func NewWriteBehind(c *Cache, interval time.Duration, maxPending int) *WriteBehind {
+	w := &WriteBehind{
+		Cache:      c,
+		interval:   interval,
+		maxPending: maxPending,
+		pending:    map[string]*Item{},
+		kick:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	return w
+}
+
+// Set buffers a write of value to key, see Cache.SetContext. IfAbsent and
+// IfPresent can't be used, as they don't survive collapsing writes.
+func (w *WriteBehind) Set(key string, value interface{}, opts ...ItemOption) error {
+	item, err := newItem(value, opts)
+	if err != nil {
+		return err
+	}
+	return w.Put(key, item)
+}
+
+// Put buffers a write of item to key.
+func (w *WriteBehind) Put(key string, item *Item) error {
+	if item.Add || item.Replace {
+		return errors.New("cache: WriteBehind can't Add or Replace")
+	}
+	copied := *item
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrWriteBehindClosed
+	}
+	if !w.started {
+		w.started = true
+		go w.run()
+	}
+	w.pending[key] = &copied
+	if w.maxPending > 0 && len(w.pending) >= w.maxPending {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Pending returns the number of keys waiting to be written.
+func (w *WriteBehind) Pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.pending)
+}
+
+// Flush writes every buffered write now. Failed writes are returned as a
+// *BatchError rather than passed to OnError, and aren't retried.
+func (w *WriteBehind) Flush(ctx context.Context) error {
+	_, _, err := w.flush(ctx)
+	return err
+}
+
+func (w *WriteBehind) flush(ctx context.Context) (map[string]*Item, []BatchResult, error) {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	items := w.pending
+	w.pending = map[string]*Item{}
+	w.mu.Unlock()
+
+	if len(items) == 0 {
+		return items, nil, nil
+	}
+	results, err := w.Cache.PutMulti(ctx, items)
+	return items, results, err
+}
+
+// Close stops the background flushes and flushes what's left, waiting for
+// at most as long as ctx allows. If ctx is done first, the writes not made
+// are reported with a *PendingWritesError. Later writes fail with
+// ErrWriteBehindClosed.
+func (w *WriteBehind) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	started := w.started
+	w.mu.Unlock()
+
+	close(w.stop)
+	if started {
+		select {
+		case <-w.done:
+		case <-ctx.Done():
+			return &PendingWritesError{Pending: w.Pending(), Err: ctx.Err()}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return &PendingWritesError{Pending: w.Pending(), Err: err}
+	}
+	return w.Flush(ctx)
+}
+
+func (w *WriteBehind) run() {
+	defer close(w.done)
+	var tick <-chan time.Time
+	if w.interval > 0 {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-tick:
+		case <-w.kick:
+		}
+		w.background()
+	}
+}
+
+// background flushes, passing failures to OnError.
+func (w *WriteBehind) background() {
+	items, results, err := w.flush(context.Background())
+	if err == nil || w.OnError == nil {
+		return
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			w.OnError(r.Key, items[r.Key], r.Err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Command ironcache-copy copies cache items between projects or regions.
+
+IronCache can't list keys, so the keys to copy are read from a manifest,
+one per line. Settings come from iron.json and the environment as usual,
+with -from and -to picking an environment of iron.json for each side:
+
+	ironcache-copy -keys keys.txt -cache sessions -from production -to eu
+
+Items can also be dumped to JSON lines and loaded later:
+
+	ironcache-copy -keys keys.txt -cache sessions -from production -dump > items.jsonl
+	ironcache-copy -cache sessions -to eu -load items.jsonl
+*/
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"strings"
+
+	"github.com/iron-io/iron_go/cache"
+)
+
+func main() {
+	keysFile := flag.String("keys", "-", "file listing the keys to copy, one per line, - for stdin")
+	cacheName := flag.String("cache", "", "name of the cache to copy")
+	toCache := flag.String("to-cache", "", "name of the cache to copy to, -cache if empty")
+	from := flag.String("from", "", "iron.json environment to copy from")
+	to := flag.String("to", "", "iron.json environment to copy to")
+	dump := flag.Bool("dump", false, "write the items to stdout instead of copying them")
+	load := flag.String("load", "", "import the items of a dump instead of copying them")
+	flag.Parse()
+
+	if *cacheName == "" {
+		fail(fmt.Errorf("-cache is required"))
+	}
+	if *toCache == "" {
+		*toCache = *cacheName
+	}
+	ctx := context.Background()
+
+	if *load != "" {
+		dst, err := cache.NewCache(*toCache, cache.WithEnv(*to))
+		if err != nil {
+			fail(err)
+		}
+		f, err := os.Open(*load)
+		if err != nil {
+			fail(err)
+		}
+		defer f.Close()
+		if err := cache.Import(ctx, dst, f); err != nil {
+			fail(err)
+		}
+		return
+	}
+
+	src, err := cache.NewCache(*cacheName, cache.WithEnv(*from))
+	if err != nil {
+		fail(err)
+	}
+	manifest := os.Stdin
+	if *keysFile != "-" {
+		if manifest, err = os.Open(*keysFile); err != nil {
+			fail(err)
+		}
+		defer manifest.Close()
+	}
+	keys, keysErr := lines(manifest)
+
+	if *dump {
+		out := bufio.NewWriter(os.Stdout)
+		if err := cache.Export(ctx, src, keys, out); err != nil {
+			fail(err)
+		}
+		if err := out.Flush(); err != nil {
+			fail(err)
+		}
+		if err := keysErr(); err != nil {
+			fail(err)
+		}
+		return
+	}
+
+	dst, err := cache.NewCache(*toCache, cache.WithEnv(*to))
+	if err != nil {
+		fail(err)
+	}
+	// stream the export into the import, so large manifests aren't held in
+	// memory.
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(cache.Export(ctx, src, keys, pw))
+	}()
+	if err := cache.Import(ctx, dst, pr); err != nil {
+		fail(err)
+	}
+	if err := keysErr(); err != nil {
+		fail(err)
+	}
+}
+
+// lines yields the non-empty lines of r, the returned func reports any
+// error reading them once they are consumed.
+func lines(r io.Reader) (iter.Seq[string], func() error) {
+	scanner := bufio.NewScanner(r)
+	seq := func(yield func(string) bool) {
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !yield(line) {
+				return
+			}
+		}
+	}
+	return seq, scanner.Err
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "ironcache-copy:", err)
+	os.Exit(1)
+}
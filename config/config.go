@@ -3,6 +3,7 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -10,6 +11,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Contains the configuration for an iron.io service.
@@ -22,6 +24,21 @@ type Settings struct {
 	Port       uint16 `json:"port,omitempty"`
 	ApiVersion string `json:"api_version,omitempty"`
 	UserAgent  string `json:"user_agent,omitempty"`
+
+	// Timeout bounds a single API call, retries included. Zero means no
+	// timeout. The durations aren't marshalled, encoding/json would write
+	// nanoseconds where config files and env values use seconds.
+	Timeout time.Duration `json:"-"`
+	// MaxRetries is the number of times a request is retried after a 503 or a
+	// dropped connection. Zero uses api.MaxRequestRetries, a negative value
+	// disables retries.
+	MaxRetries int `json:"max_retries,omitempty"`
+	// RetryBackoff is the base delay between retries, it grows quadratically
+	// with each attempt. Zero uses the api default of 100ms.
+	RetryBackoff time.Duration `json:"-"`
+	// LongPollWait is how long a queue read waits for messages to arrive when
+	// the caller doesn't ask for a specific wait. Zero returns immediately.
+	LongPollWait time.Duration `json:"-"`
 }
 
 var (
@@ -87,14 +104,16 @@ func ConfigWithEnv(fullProduct, env string) (settings Settings) {
 	return config(fullProduct, env, nil)
 }
 
-func config(fullProduct, env string, configuration *Settings) Settings {
+// Load is like ManualConfig with an env, but reports invalid product names,
+// config files and env values as an error instead of panicking.
+func Load(fullProduct, env string, configuration *Settings) (Settings, error) {
 	if os.Getenv("IRON_CONFIG_DEBUG") != "" {
 		debug = true
 		dbg("debugging of config enabled")
 	}
 	pair := strings.SplitN(fullProduct, "_", 2)
 	if len(pair) != 2 {
-		panic("Invalid product name, has to use prefix.")
+		return Settings{}, errors.New("Invalid product name, has to use prefix.")
 	}
 	family, product := pair[0], pair[1]
 
@@ -110,23 +129,39 @@ func config(fullProduct, env string, configuration *Settings) Settings {
 		}
 	}
 
-	base.globalConfig(family, product, env)
-	base.globalEnv(family, product)
-	base.productEnv(family, product)
-	base.localConfig(family, product, env)
+	if err := base.globalConfig(family, product, env); err != nil {
+		return base, err
+	}
+	if err := base.globalEnv(family, product); err != nil {
+		return base, err
+	}
+	if err := base.productEnv(family, product); err != nil {
+		return base, err
+	}
+	if err := base.localConfig(family, product, env); err != nil {
+		return base, err
+	}
 	base.manualConfig(configuration)
 
-	return base
+	return base, nil
+}
+
+func config(fullProduct, env string, configuration *Settings) Settings {
+	settings, err := Load(fullProduct, env, configuration)
+	if err != nil {
+		panic(err.Error())
+	}
+	return settings
 }
 
-func (s *Settings) globalConfig(family, product, env string) {
+func (s *Settings) globalConfig(family, product, env string) error {
 	home, err := homeDir()
 	if err != nil {
 		fmt.Println("Error getting home directory:", err)
-		return
+		return nil
 	}
 	path := filepath.Join(home, ".iron.json")
-	s.UseConfigFile(family, product, path, env)
+	return s.useConfigFile(family, product, path, env)
 }
 
 // The environment variables the scheme looks for are all of the same formula:
@@ -135,20 +170,20 @@ func (s *Settings) globalConfig(family, product, env string) {
 // global environment variables, “IRON” is used by itself. The value being
 // loaded is then joined by an underscore to the name, and again capitalised.
 // For example, to retrieve the OAuth token, the client looks for “IRON_TOKEN”.
-func (s *Settings) globalEnv(family, product string) {
+func (s *Settings) globalEnv(family, product string) error {
 	eFamily := strings.ToUpper(family) + "_"
-	s.commonEnv(eFamily)
+	return s.commonEnv(eFamily)
 }
 
 // In the case of product-specific variables (which override global variables),
 // it would be “IRON_WORKER_TOKEN” (for IronWorker).
-func (s *Settings) productEnv(family, product string) {
+func (s *Settings) productEnv(family, product string) error {
 	eProduct := strings.ToUpper(family) + "_" + strings.ToUpper(product) + "_"
-	s.commonEnv(eProduct)
+	return s.commonEnv(eProduct)
 }
 
-func (s *Settings) localConfig(family, product, env string) {
-	s.UseConfigFile(family, product, "iron.json", env)
+func (s *Settings) localConfig(family, product, env string) error {
+	return s.useConfigFile(family, product, "iron.json", env)
 }
 
 func (s *Settings) manualConfig(settings *Settings) {
@@ -157,7 +192,7 @@ func (s *Settings) manualConfig(settings *Settings) {
 	}
 }
 
-func (s *Settings) commonEnv(prefix string) {
+func (s *Settings) commonEnv(prefix string) (err error) {
 	if token := os.Getenv(prefix + "TOKEN"); token != "" {
 		s.Token = token
 		dbg("env has TOKEN:", s.Token)
@@ -177,7 +212,7 @@ func (s *Settings) commonEnv(prefix string) {
 	if port := os.Getenv(prefix + "PORT"); port != "" {
 		n, err := strconv.ParseUint(port, 10, 16)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("%sPORT: %v", prefix, err)
 		}
 		s.Port = uint16(n)
 		dbg("env has PORT:", s.Port)
@@ -186,20 +221,67 @@ func (s *Settings) commonEnv(prefix string) {
 		s.ApiVersion = vers
 		dbg("env has API_VERSION:", s.ApiVersion)
 	}
+	if timeout := os.Getenv(prefix + "TIMEOUT"); timeout != "" {
+		if s.Timeout, err = durationValue(timeout); err != nil {
+			return fmt.Errorf("%sTIMEOUT: %v", prefix, err)
+		}
+		dbg("env has TIMEOUT:", s.Timeout)
+	}
+	if retries := os.Getenv(prefix + "MAX_RETRIES"); retries != "" {
+		if s.MaxRetries, err = strconv.Atoi(retries); err != nil {
+			return fmt.Errorf("%sMAX_RETRIES: %v", prefix, err)
+		}
+		dbg("env has MAX_RETRIES:", s.MaxRetries)
+	}
+	if backoff := os.Getenv(prefix + "RETRY_BACKOFF"); backoff != "" {
+		if s.RetryBackoff, err = durationValue(backoff); err != nil {
+			return fmt.Errorf("%sRETRY_BACKOFF: %v", prefix, err)
+		}
+		dbg("env has RETRY_BACKOFF:", s.RetryBackoff)
+	}
+	if wait := os.Getenv(prefix + "LONG_POLL_WAIT"); wait != "" {
+		if s.LongPollWait, err = durationValue(wait); err != nil {
+			return fmt.Errorf("%sLONG_POLL_WAIT: %v", prefix, err)
+		}
+		dbg("env has LONG_POLL_WAIT:", s.LongPollWait)
+	}
+	return nil
+}
+
+// durationValue reads a duration from a config file or env value. Plain
+// numbers are seconds, strings may also use time.ParseDuration syntax such as
+// "1m30s".
+func durationValue(value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+	case float64:
+		return time.Duration(v * float64(time.Second)), nil
+	case string:
+		if secs, err := strconv.ParseFloat(v, 64); err == nil {
+			return time.Duration(secs * float64(time.Second)), nil
+		}
+		return time.ParseDuration(v)
+	}
+	return 0, fmt.Errorf("invalid duration: %#v", value)
 }
 
 // Load and merge the given JSON config file.
 func (s *Settings) UseConfigFile(family, product, path, env string) {
+	if err := s.useConfigFile(family, product, path, env); err != nil {
+		panic(err.Error())
+	}
+}
+
+func (s *Settings) useConfigFile(family, product, path, env string) error {
 	content, err := ioutil.ReadFile(path)
 	if err != nil {
 		dbg("tried to", err, ": skipping")
-		return
+		return nil
 	}
 
 	data := map[string]interface{}{}
 	err = json.Unmarshal(content, &data)
 	if err != nil {
-		panic("Invalid JSON in " + path + ": " + err.Error())
+		return errors.New("Invalid JSON in " + path + ": " + err.Error())
 	}
 
 	dbg("config in", path, "found")
@@ -207,49 +289,109 @@ func (s *Settings) UseConfigFile(family, product, path, env string) {
 	if env != "" {
 		envdata, ok := data[env].(map[string]interface{})
 		if !ok {
-			return // bail, they specified an env but we couldn't find one, so error out.
+			return nil // bail, they specified an env but we couldn't find one, so error out.
 		}
 		data = envdata
 	}
-	s.UseConfigMap(data)
+	if err = s.useConfigMap(data); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
 
 	ipData, found := data[family+"_"+product]
 	if found {
-		pData := ipData.(map[string]interface{})
-		s.UseConfigMap(pData)
+		pData, ok := ipData.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %s_%s has to be an object", path, family, product)
+		}
+		if err = s.useConfigMap(pData); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
 	}
+	return nil
 }
 
 // Merge the given data into the settings.
 func (s *Settings) UseConfigMap(data map[string]interface{}) {
-	if token, found := data["token"]; found {
-		s.Token = token.(string)
+	if err := s.useConfigMap(data); err != nil {
+		panic(err.Error())
+	}
+}
+
+func (s *Settings) useConfigMap(data map[string]interface{}) (err error) {
+	str := func(key string, dst *string) bool {
+		v, found := data[key]
+		if !found {
+			return false
+		}
+		str, ok := v.(string)
+		if !ok {
+			err = fmt.Errorf("%s has to be a string, got %#v", key, v)
+			return false
+		}
+		*dst = str
+		return true
+	}
+	num := func(key string) (float64, bool) {
+		v, found := data[key]
+		if !found {
+			return 0, false
+		}
+		n, ok := v.(float64)
+		if !ok {
+			err = fmt.Errorf("%s has to be a number, got %#v", key, v)
+		}
+		return n, ok
+	}
+	dur := func(key string, dst *time.Duration) bool {
+		v, found := data[key]
+		if !found {
+			return false
+		}
+		d, derr := durationValue(v)
+		if derr != nil {
+			err = fmt.Errorf("%s: %v", key, derr)
+			return false
+		}
+		*dst = d
+		return true
+	}
+
+	if str("token", &s.Token) {
 		dbg("config has token:", s.Token)
 	}
-	if projectId, found := data["project_id"]; found {
-		s.ProjectId = projectId.(string)
+	if str("project_id", &s.ProjectId) {
 		dbg("config has project_id:", s.ProjectId)
 	}
-	if host, found := data["host"]; found {
-		s.Host = host.(string)
+	if str("host", &s.Host) {
 		dbg("config has host:", s.Host)
 	}
-	if prot, found := data["scheme"]; found {
-		s.Scheme = prot.(string)
+	if str("scheme", &s.Scheme) {
 		dbg("config has scheme:", s.Scheme)
 	}
-	if port, found := data["port"]; found {
-		s.Port = uint16(port.(float64))
+	if port, ok := num("port"); ok {
+		s.Port = uint16(port)
 		dbg("config has port:", s.Port)
 	}
-	if vers, found := data["api_version"]; found {
-		s.ApiVersion = vers.(string)
+	if str("api_version", &s.ApiVersion) {
 		dbg("config has api_version:", s.ApiVersion)
 	}
-	if agent, found := data["user_agent"]; found {
-		s.UserAgent = agent.(string)
+	if str("user_agent", &s.UserAgent) {
 		dbg("config has user_agent:", s.UserAgent)
 	}
+	if dur("timeout", &s.Timeout) {
+		dbg("config has timeout:", s.Timeout)
+	}
+	if retries, ok := num("max_retries"); ok {
+		s.MaxRetries = int(retries)
+		dbg("config has max_retries:", s.MaxRetries)
+	}
+	if dur("retry_backoff", &s.RetryBackoff) {
+		dbg("config has retry_backoff:", s.RetryBackoff)
+	}
+	if dur("long_poll_wait", &s.LongPollWait) {
+		dbg("config has long_poll_wait:", s.LongPollWait)
+	}
+	return err
 }
 
 // Merge the given instance into the settings.
@@ -275,4 +417,16 @@ func (s *Settings) UseSettings(settings *Settings) {
 	if settings.Port > 0 {
 		s.Port = settings.Port
 	}
+	if settings.Timeout != 0 {
+		s.Timeout = settings.Timeout
+	}
+	if settings.MaxRetries != 0 {
+		s.MaxRetries = settings.MaxRetries
+	}
+	if settings.RetryBackoff != 0 {
+		s.RetryBackoff = settings.RetryBackoff
+	}
+	if settings.LongPollWait != 0 {
+		s.LongPollWait = settings.LongPollWait
+	}
 }
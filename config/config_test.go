@@ -1,9 +1,13 @@
 package config_test
 
 import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
 	"github.com/iron-io/iron_go/config"
 	. "github.com/jeffh/go.bdd"
-	"testing"
 )
 
 func init() {
@@ -13,6 +17,84 @@ func init() {
 			s := config.Config("iron_undefined")
 			Expect(s.Host, ToEqual, "undefined-aws-us-east-1.iron.io")
 		})
+
+		It("reads request tuning from the environment", func() {
+			os.Setenv("IRON_TIMEOUT", "30")
+			os.Setenv("IRON_UNDEFINED_TIMEOUT", "1m30s")
+			os.Setenv("IRON_UNDEFINED_MAX_RETRIES", "2")
+			defer os.Unsetenv("IRON_TIMEOUT")
+			defer os.Unsetenv("IRON_UNDEFINED_TIMEOUT")
+			defer os.Unsetenv("IRON_UNDEFINED_MAX_RETRIES")
+
+			s := config.Config("iron_undefined")
+			Expect(s.Timeout, ToEqual, 90*time.Second)
+			Expect(s.MaxRetries, ToEqual, 2)
+		})
+
+		It("leaves durations out of JSON, which would write them as nanoseconds", func() {
+			out, err := json.Marshal(config.Settings{Token: "token", Timeout: time.Minute, RetryBackoff: time.Second})
+			Expect(err, ToBeNil)
+			Expect(string(out), ToEqual, `{"token":"token"}`)
+		})
+
+		It("returns invalid configuration as an error", func() {
+			_, err := config.Load("undefined", "", nil)
+			Expect(err, ToNotBeNil)
+
+			os.Setenv("IRON_UNDEFINED_PORT", "not a port")
+			defer os.Unsetenv("IRON_UNDEFINED_PORT")
+			_, err = config.Load("iron_undefined", "", nil)
+			Expect(err, ToNotBeNil)
+		})
+
+		It("validates settings", func() {
+			s := config.Presets["mq"]
+			s.Token = "token"
+			s.ProjectId = "4f2a7c1b9e8d6f3a2b1c0d9e"
+			Expect(s.Validate("mq"), ToBeNil)
+
+			s.Host = "https://mq-aws-us-east-1.iron.io"
+			s.ApiVersion = "3"
+			errs, ok := s.Validate("mq").(config.ValidationErrors)
+			Expect(ok, ToEqual, true)
+			Expect(len(errs), ToEqual, 2)
+			Expect(errs[0].Field, ToEqual, "host")
+			Expect(errs[1].Field, ToEqual, "api_version")
+		})
+
+		It("accepts IPv6 hosts but not hosts with ports", func() {
+			s := config.Presets["mq"]
+			s.Token = "token"
+			s.ProjectId = "4f2a7c1b9e8d6f3a2b1c0d9e"
+			for _, host := range []string{"::1", "[fe80::1]", "127.0.0.1", "localhost"} {
+				s.Host = host
+				Expect(s.Validate("mq"), ToBeNil)
+			}
+			for _, host := range []string{"localhost:8080", "[::1]:443"} {
+				s.Host = host
+				errs, _ := s.Validate("mq").(config.ValidationErrors)
+				Expect(len(errs), ToEqual, 1)
+			}
+		})
+
+		It("reads request tuning from config maps", func() {
+			s := config.Settings{}
+			s.UseConfigMap(map[string]interface{}{
+				"retry_backoff":  0.25,
+				"long_poll_wait": "20s",
+			})
+			Expect(s.RetryBackoff, ToEqual, 250*time.Millisecond)
+			Expect(s.LongPollWait, ToEqual, 20*time.Second)
+		})
+
+		It("keeps earlier values when a config value has the wrong type", func() {
+			s := config.Settings{Token: "from-env"}
+			func() {
+				defer func() { recover() }()
+				s.UseConfigMap(map[string]interface{}{"token": 42.0})
+			}()
+			Expect(s.Token, ToEqual, "from-env")
+		})
 	})
 }
 
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ApiVersions lists the API versions this library can talk to, keyed by the
+// product's Presets name.
+var ApiVersions = map[string][]string{
+	"worker": {"2"},
+	"mq":     {"1"},
+	"cache":  {"1"},
+}
+
+var projectIdFormat = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+// ValidationError describes one problem with a Settings field.
+type ValidationError struct {
+	Field   string
+	Problem string
+	// Hint suggests how to fix the problem.
+	Hint string
+}
+
+func (e ValidationError) Error() string { return e.Field + ": " + e.Problem }
+
+// ValidationErrors is returned by Validate when any field is invalid.
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate checks the settings are complete and sane enough to make requests
+// to the given product, e.g. "mq". The api version is only checked for
+// products listed in ApiVersions. A non-nil error is always ValidationErrors.
+func (s Settings) Validate(product string) error {
+	errs := ValidationErrors{}
+	add := func(field, problem, hint string) {
+		errs = append(errs, ValidationError{Field: field, Problem: problem, Hint: hint})
+	}
+
+	switch {
+	case s.Token == "":
+		add("token", "is missing", "set IRON_TOKEN or \"token\" in iron.json, see http://dev.iron.io/articles/configuration")
+	case strings.TrimSpace(s.Token) != s.Token || strings.ContainsAny(s.Token, " \t\r\n"):
+		add("token", "contains whitespace", "check the token wasn't copied with surrounding spaces or newlines")
+	}
+
+	switch {
+	case s.ProjectId == "":
+		add("project_id", "is missing", "set IRON_PROJECT_ID or \"project_id\" in iron.json, see http://dev.iron.io/articles/configuration")
+	case !projectIdFormat.MatchString(s.ProjectId):
+		add("project_id", fmt.Sprintf("%q is not a 24 character hex id", s.ProjectId), "copy the project id from the project's credentials on hud.iron.io")
+	}
+
+	switch s.Scheme {
+	case "http", "https":
+	case "":
+		add("scheme", "is missing", "use \"https\"")
+	default:
+		add("scheme", fmt.Sprintf("%q is not http or https", s.Scheme), "use \"https\"")
+	}
+
+	switch {
+	case s.Host == "":
+		add("host", "is missing", "remove the host setting to use the default for "+product)
+	case strings.Contains(s.Host, "://") || strings.ContainsAny(s.Host, "/?#"):
+		add("host", fmt.Sprintf("%q is a URL, not a host name", s.Host), "put only the host name in \"host\" and use \"scheme\" and \"port\" for the rest")
+	default:
+		ip := strings.TrimSuffix(strings.TrimPrefix(s.Host, "["), "]")
+		_, _, portErr := net.SplitHostPort(s.Host)
+		_, urlErr := url.Parse("//" + s.Host)
+		switch {
+		case portErr == nil:
+			add("host", fmt.Sprintf("%q includes a port", s.Host), "put only the host name in \"host\" and use \"port\" for the port")
+		case net.ParseIP(ip) != nil:
+			// an IP literal, IPv6 ones with or without brackets
+		case urlErr != nil || strings.Contains(s.Host, ":"):
+			add("host", fmt.Sprintf("%q is not a valid host name", s.Host), "put only the host name in \"host\" and use \"port\" for the port")
+		}
+	}
+
+	switch {
+	case s.Port == 0:
+		add("port", "is missing", "use 443 for https")
+	case s.Scheme == "https" && s.Port == 80:
+		add("port", "80 is the http port but scheme is https", "use port 443 or scheme http")
+	case s.Scheme == "http" && s.Port == 443:
+		add("port", "443 is the https port but scheme is http", "use port 80 or scheme https")
+	}
+
+	if versions, found := ApiVersions[product]; found {
+		supported := false
+		for _, v := range versions {
+			supported = supported || v == s.ApiVersion
+		}
+		if !supported {
+			add("api_version", fmt.Sprintf("%q is not supported by this library's %s client", s.ApiVersion, product),
+				fmt.Sprintf("use api_version %q or remove the setting", versions[0]))
+		}
+	}
+
+	if s.Timeout < 0 {
+		add("timeout", "is negative", "use 0 for no timeout")
+	}
+	if s.LongPollWait < 0 {
+		add("long_poll_wait", "is negative", "use 0 to return immediately")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package iron
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/iron-io/iron_go/api"
+	"github.com/iron-io/iron_go/config"
+)
+
+// Check is the outcome of one diagnostic step run by Doctor.
+type Check struct {
+	Product string
+	// Name is one of "config", "validate", "version" or "auth".
+	Name string
+	Err  error
+	// Hint suggests how to fix a failed check.
+	Hint string
+}
+
+func (c Check) OK() bool { return c.Err == nil }
+
+// Report collects the checks run by Doctor in the order they ran.
+type Report struct {
+	Checks []Check
+}
+
+// OK reports whether every check passed.
+func (r *Report) OK() bool {
+	for _, c := range r.Checks {
+		if !c.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// Failed returns the checks that didn't pass.
+func (r *Report) Failed() []Check {
+	failed := []Check{}
+	for _, c := range r.Checks {
+		if !c.OK() {
+			failed = append(failed, c)
+		}
+	}
+	return failed
+}
+
+func (r *Report) String() string {
+	b := &strings.Builder{}
+	for _, c := range r.Checks {
+		if c.OK() {
+			fmt.Fprintf(b, "ok   %s %s\n", c.Product, c.Name)
+			continue
+		}
+		fmt.Fprintf(b, "FAIL %s %s: %v\n", c.Product, c.Name, c.Err)
+		if c.Hint != "" {
+			fmt.Fprintf(b, "     hint: %s\n", c.Hint)
+		}
+	}
+	return b.String()
+}
+
+func (r *Report) add(product, name string, err error, hint string) {
+	if err == nil {
+		hint = ""
+	}
+	r.Checks = append(r.Checks, Check{Product: product, Name: name, Err: err, Hint: hint})
+}
+
+// Doctor resolves configuration the same way NewClient does and then runs
+// the Client's diagnostics. Configuration errors are reported rather than
+// returned.
+func Doctor(ctx context.Context, opts ...Option) *Report {
+	c, err := NewClient(opts...)
+	if err != nil {
+		r := &Report{}
+		r.add("", "config", err, "fix the iron.json file or IRON_* environment variable named in the error")
+		return r
+	}
+	return c.Doctor(ctx)
+}
+
+// listEndpoints are the cheapest authenticated calls for each product.
+var listEndpoints = map[string]string{
+	"mq":     "queues",
+	"cache":  "caches",
+	"worker": "codes",
+}
+
+// Doctor validates every product's settings, then checks the service is
+// reachable and the token can list its queues, caches or code packages.
+// Network checks are skipped for products whose settings are invalid.
+func (c *Client) Doctor(ctx context.Context) *Report {
+	r := &Report{}
+	for _, product := range Products {
+		settings := c.settings[product]
+
+		if !c.validate(r, product, settings) {
+			continue
+		}
+
+		version := api.VersionAction(settings)
+		version.HttpClient = c.httpClient
+		err := version.ReqContext(ctx, "GET", nil, nil)
+		r.add(product, "version", err, reachHint(settings, err))
+		if err != nil {
+			continue
+		}
+
+		list := api.Action(settings, listEndpoints[product]).QueryAdd("per_page", "%d", 1)
+		list.HttpClient = c.httpClient
+		err = list.ReqContext(ctx, "GET", nil, nil)
+		r.add(product, "auth", err, authHint(product, err))
+	}
+	return r
+}
+
+func (c *Client) validate(r *Report, product string, settings config.Settings) bool {
+	err := settings.Validate(product)
+	errs, _ := err.(config.ValidationErrors)
+	if c.credentials != nil {
+		// the token comes from the CredentialProvider instead
+		kept := config.ValidationErrors{}
+		for _, e := range errs {
+			if e.Field != "token" {
+				kept = append(kept, e)
+			}
+		}
+		errs = kept
+	}
+	if len(errs) == 0 {
+		r.add(product, "validate", nil, "")
+		return true
+	}
+	for _, e := range errs {
+		r.add(product, "validate", e, e.Hint)
+	}
+	return false
+}
+
+func reachHint(settings config.Settings, err error) string {
+	var dnsErr *net.DNSError
+	var urlErr *url.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &dnsErr):
+		return fmt.Sprintf("%q doesn't resolve, check the host setting and your DNS", settings.Host)
+	case errors.Is(err, context.DeadlineExceeded):
+		return "the request timed out, check the network path to " + settings.Host + " or raise the timeout setting"
+	case errors.As(err, &urlErr):
+		return fmt.Sprintf("couldn't connect to %s://%s:%d, check the scheme, host and port settings and any proxy or firewall", settings.Scheme, settings.Host, settings.Port)
+	}
+	return "check the host setting points at an iron.io API server"
+}
+
+func authHint(product string, err error) string {
+	e, ok := err.(api.HTTPResponseError)
+	if !ok {
+		return ""
+	}
+	switch e.Response().StatusCode {
+	case http.StatusUnauthorized:
+		return "the token was rejected, check it belongs to the project and hasn't been revoked"
+	case http.StatusNotFound:
+		return "the project wasn't found, check project_id and that the " + product + " service is enabled for it"
+	case http.StatusForbidden:
+		return "the token can't access " + product + " for this project, check its permissions"
+	}
+	return ""
+}
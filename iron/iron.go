@@ -0,0 +1,219 @@
+// iron.io client sharing one transport and configuration across IronMQ,
+// IronCache and IronWorker
+package iron
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/iron-io/iron_go/cache"
+	"github.com/iron-io/iron_go/config"
+	"github.com/iron-io/iron_go/mq"
+	"github.com/iron-io/iron_go/worker"
+)
+
+// Products lists the services a Client resolves configuration for, keyed by
+// their config.Presets name.
+var Products = []string{"mq", "cache", "worker"}
+
+// CredentialProvider supplies the OAuth token for each request, e.g. to
+// rotate tokens without rebuilding the Client.
+type CredentialProvider interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// CredentialsFunc adapts a function to a CredentialProvider.
+type CredentialsFunc func(ctx context.Context) (string, error)
+
+func (f CredentialsFunc) Token(ctx context.Context) (string, error) { return f(ctx) }
+
+// Logger receives one line per request. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Metrics is told about every request made through the Client. err is the
+// transport error, if any; API errors show up as the status code.
+type Metrics interface {
+	ObserveRequest(method, host string, status int, elapsed time.Duration, err error)
+}
+
+// RetryPolicy is applied to the settings of every product. Unlike
+// config.Settings, a MaxRetries of zero disables retries.
+type RetryPolicy struct {
+	MaxRetries int
+	Backoff    time.Duration
+}
+
+// Client hands out Queue, Cache and Worker objects that share its
+// http.Client and settings.
+type Client struct {
+	settings    map[string]config.Settings
+	httpClient  *http.Client
+	credentials CredentialProvider
+}
+
+type options struct {
+	env         string
+	settings    config.Settings
+	httpClient  *http.Client
+	credentials CredentialProvider
+	logger      Logger
+	metrics     Metrics
+	retry       *RetryPolicy
+}
+
+// Option configures a Client created by NewClient.
+type Option func(*options)
+
+// WithSettings merges the given settings over the ones found in iron.json
+// files and environment variables for every product. Leave Host and
+// ApiVersion empty to keep each product's own.
+func WithSettings(settings config.Settings) Option {
+	return func(o *options) { o.settings = settings }
+}
+
+// WithEnv picks the named environment out of iron.json files, see
+// config.ConfigWithEnv.
+func WithEnv(env string) Option {
+	return func(o *options) { o.env = env }
+}
+
+// WithHTTPClient makes all requests using a copy of the given http.Client.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) { o.httpClient = client }
+}
+
+// WithCredentials takes the OAuth token from provider instead of the
+// configured one.
+func WithCredentials(provider CredentialProvider) Option {
+	return func(o *options) { o.credentials = provider }
+}
+
+// WithLogger logs every request to logger.
+func WithLogger(logger Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithMetrics reports every request to metrics.
+func WithMetrics(metrics Metrics) Option {
+	return func(o *options) { o.metrics = metrics }
+}
+
+// WithRetryPolicy overrides the configured retries for every product.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *options) { o.retry = &policy }
+}
+
+// NewClient resolves the configuration of every product up front, so
+// invalid configuration is reported here rather than on first use.
+func NewClient(opts ...Option) (*Client, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.retry != nil {
+		o.settings.MaxRetries = o.retry.MaxRetries
+		o.settings.RetryBackoff = o.retry.Backoff
+		if o.settings.MaxRetries == 0 {
+			o.settings.MaxRetries = -1
+		}
+	}
+
+	c := &Client{settings: map[string]config.Settings{}, credentials: o.credentials}
+	for _, product := range Products {
+		settings, err := config.Load("iron_"+product, o.env, &o.settings)
+		if err != nil {
+			return nil, err
+		}
+		c.settings[product] = settings
+	}
+
+	// copy the caller's client, so neither side sees the other's changes
+	hc := http.Client{}
+	if o.httpClient != nil {
+		hc = *o.httpClient
+	}
+	c.httpClient = &hc
+	if o.credentials != nil || o.logger != nil || o.metrics != nil {
+		hc.Transport = &transport{
+			base:        hc.Transport,
+			credentials: o.credentials,
+			logger:      o.logger,
+			metrics:     o.metrics,
+		}
+	}
+
+	return c, nil
+}
+
+// Settings returns the resolved settings for product, one of Products.
+func (c *Client) Settings(product string) config.Settings {
+	return c.settings[product]
+}
+
+// HTTPClient returns the http.Client shared by everything the Client creates.
+func (c *Client) HTTPClient() *http.Client {
+	return c.httpClient
+}
+
+// MQ returns the named queue.
+func (c *Client) MQ(queueName string) *mq.Queue {
+	return &mq.Queue{Settings: c.settings["mq"], Name: queueName, HttpClient: c.httpClient}
+}
+
+// Cache returns the named cache.
+func (c *Client) Cache(cacheName string) *cache.Cache {
+	return &cache.Cache{Settings: c.settings["cache"], Name: cacheName, HttpClient: c.httpClient}
+}
+
+// Worker returns an IronWorker client.
+func (c *Client) Worker() *worker.Worker {
+	return &worker.Worker{Settings: c.settings["worker"], HttpClient: c.httpClient}
+}
+
+type transport struct {
+	base        http.RoundTripper
+	credentials CredentialProvider
+	logger      Logger
+	metrics     Metrics
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if t.credentials != nil {
+		token, err := t.credentials.Token(req.Context())
+		if err != nil {
+			return nil, err
+		}
+		// a RoundTripper must not modify the caller's request
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "OAuth "+token)
+	}
+
+	start := time.Now()
+	response, err := base.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	status := 0
+	if response != nil {
+		status = response.StatusCode
+	}
+	if t.logger != nil {
+		if err != nil {
+			t.logger.Printf("iron: %s %s: %v (%s)", req.Method, req.URL, err, elapsed)
+		} else {
+			t.logger.Printf("iron: %s %s: %d (%s)", req.Method, req.URL, status, elapsed)
+		}
+	}
+	if t.metrics != nil {
+		t.metrics.ObserveRequest(req.Method, req.URL.Host, status, elapsed, err)
+	}
+
+	return response, err
+}
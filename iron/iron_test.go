@@ -0,0 +1,110 @@
+package iron_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iron-io/iron_go/config"
+	"github.com/iron-io/iron_go/iron"
+	. "github.com/jeffh/go.bdd"
+)
+
+type countingMetrics struct {
+	sync.Mutex
+	requests int
+}
+
+func (m *countingMetrics) ObserveRequest(method, host string, status int, elapsed time.Duration, err error) {
+	m.Lock()
+	m.requests++
+	m.Unlock()
+}
+
+func TestEverything(t *testing.T) {
+	defer PrintSpecReport()
+
+	auth := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth <- r.Header.Get("Authorization")
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	host, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	settings := config.Settings{
+		Token:     "configured",
+		ProjectId: "4f2a7c1b9e8d6f3a2b1c0d9e",
+		Host:      host,
+		Port:      uint16(portNum),
+		Scheme:    "http",
+	}
+
+	Describe("iron.Client", func() {
+		It("takes product defaults from the presets", func() {
+			c, err := iron.NewClient(iron.WithSettings(config.Settings{Token: "t"}))
+			Expect(err, ToBeNil)
+			Expect(c.Settings("mq").Host, ToEqual, config.Presets["mq"].Host)
+			Expect(c.Settings("worker").ApiVersion, ToEqual, config.Presets["worker"].ApiVersion)
+		})
+
+		It("shares credentials and metrics across products", func() {
+			metrics := &countingMetrics{}
+			c, err := iron.NewClient(
+				iron.WithSettings(settings),
+				iron.WithMetrics(metrics),
+				iron.WithCredentials(iron.CredentialsFunc(func(context.Context) (string, error) {
+					return "rotated", nil
+				})),
+			)
+			Expect(err, ToBeNil)
+
+			_, err = c.MQ("queue").Info()
+			Expect(err, ToBeNil)
+			Expect(<-auth, ToEqual, "OAuth rotated")
+
+			_, err = c.Cache("cache").Get("key")
+			Expect(err, ToBeNil)
+			Expect(<-auth, ToEqual, "OAuth rotated")
+
+			Expect(metrics.requests, ToEqual, 2)
+		})
+
+		It("copies the given http.Client", func() {
+			client := &http.Client{Timeout: time.Minute}
+			c, err := iron.NewClient(iron.WithSettings(settings), iron.WithHTTPClient(client))
+			Expect(err, ToBeNil)
+			Expect(c.HTTPClient() != client, ToEqual, true)
+			Expect(c.HTTPClient().Timeout, ToEqual, time.Minute)
+		})
+
+		It("diagnoses a working setup", func() {
+			report := iron.Doctor(context.Background(), iron.WithSettings(settings))
+			for range report.Checks {
+				select {
+				case <-auth:
+				default:
+				}
+			}
+			Expect(report.OK(), ToEqual, true)
+			Expect(len(report.Checks), ToEqual, 3*len(iron.Products))
+		})
+
+		It("hints at invalid settings", func() {
+			bad := settings
+			bad.ProjectId = "my-project"
+			report := iron.Doctor(context.Background(), iron.WithSettings(bad))
+			Expect(report.OK(), ToEqual, false)
+			failed := report.Failed()
+			Expect(len(failed), ToEqual, len(iron.Products))
+			Expect(failed[0].Name, ToEqual, "validate")
+			Expect(failed[0].Hint == "", ToEqual, false)
+		})
+	})
+}
@@ -0,0 +1,269 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrConsumerClosed is returned by Run once the Consumer was shut down.
+var ErrConsumerClosed = errors.New("mq: Consumer is shut down")
+
+// Handler processes a message. Returning nil deletes the message, an error
+// releases it back onto the queue to be retried.
+type Handler func(ctx context.Context, msg *Message) error
+
+// Consumer runs a Handler for the messages of a Queue on a pool of
+// goroutines, deleting the messages it handled and releasing the ones it
+// failed.
+//
+//	c := mq.NewConsumer(q, func(ctx context.Context, msg *mq.Message) error {
+//		return process(ctx, msg.Body)
+//	})
+//	c.Concurrency = 8
+//	go c.Run(ctx)
+//	...
+//	c.Shutdown(shutdownCtx)
+type Consumer struct {
+	Queue   *Queue
+	Handler Handler
+	// Concurrency is how many messages are handled at once, 1 if zero.
+	Concurrency int
+	// BatchSize is how many messages are reserved per request, Concurrency
+	// if zero. Messages of a batch wait for a free goroutine while their
+	// reservation runs, so keep it close to Concurrency.
+	BatchSize int
+	// Wait is how long a request waits for messages to arrive, the queue's
+	// LongPollWait setting if zero. It's rounded up to whole seconds.
+	Wait time.Duration
+	// Timeout is how long a message stays reserved for its handler, the
+	// server's default if zero. Unfinished messages then go back onto the
+	// queue, so it has to be longer than the handler takes. It's rounded up
+	// to whole seconds.
+	Timeout time.Duration
+	// Backoff returns how long a failed message waits before it's retried,
+	// given how often it was reserved, rounded up to whole seconds.
+	// DefaultBackoff if nil.
+	Backoff func(reservedCount int64) time.Duration
+	// OnError, when set, is told about every error: the handler's, with its
+	// message, and those of the queue requests.
+	OnError func(msg *Message, err error)
+
+	mu     sync.Mutex
+	closed bool
+	stop   chan struct{}
+	done   chan struct{}
+	cancel context.CancelFunc
+}
+
+// NewConsumer returns a Consumer running handler for the messages of q.
+func NewConsumer(q *Queue, handler Handler) *Consumer {
+	return &Consumer{Queue: q, Handler: handler}
+}
+
+// DefaultBackoff waits a second after the first failure, doubling with
+// every further one up to 15 minutes.
+func DefaultBackoff(reservedCount int64) time.Duration {
+	delay := time.Second
+	for i := int64(1); i < reservedCount && delay < 15*time.Minute; i++ {
+		delay *= 2
+	}
+	if delay > 15*time.Minute {
+		delay = 15 * time.Minute
+	}
+	return delay
+}
+
+// Run reserves and handles messages until Shutdown is called or ctx is done.
+// Cancelling ctx also cancels the handlers' ctx, Shutdown lets them finish.
+// Run returns nil after a Shutdown, ctx's error otherwise.
+func (c *Consumer) Run(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closed || c.done != nil {
+		c.mu.Unlock()
+		return ErrConsumerClosed
+	}
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+	handlerCtx, cancel := context.WithCancel(ctx)
+	c.cancel = cancel
+	stop, done := c.stop, c.done
+	c.mu.Unlock()
+	defer close(done)
+	defer cancel()
+
+	concurrency := c.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	jobs := make(chan *Message)
+	wg := sync.WaitGroup{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range jobs {
+				c.handle(handlerCtx, msg)
+			}
+		}()
+	}
+
+	fetchCtx, stopFetching := context.WithCancel(ctx)
+	defer stopFetching()
+	go func() {
+		select {
+		case <-stop:
+			stopFetching()
+		case <-fetchCtx.Done():
+		}
+	}()
+
+	c.fetch(fetchCtx, jobs)
+	close(jobs)
+	wg.Wait()
+	return ctx.Err()
+}
+
+// fetch reserves messages and hands them to the pool until ctx is done.
+// Sending blocks while the pool is busy, which keeps it from reserving
+// messages it can't handle yet.
+func (c *Consumer) fetch(ctx context.Context, jobs chan<- *Message) {
+	batch := c.BatchSize
+	if batch <= 0 {
+		batch = c.Concurrency
+	}
+	if batch <= 0 {
+		batch = 1
+	}
+	wait := c.Queue.longPollWait()
+	if c.Wait > 0 {
+		wait = seconds(c.Wait)
+	}
+	timeout := seconds(c.Timeout)
+
+	retry := time.Second
+	for ctx.Err() == nil {
+		msgs, err := c.Queue.GetNWithTimeoutAndWaitContext(ctx, batch, timeout, wait)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.report(nil, err)
+			select {
+			case <-time.After(retry):
+			case <-ctx.Done():
+			}
+			if retry < 30*time.Second {
+				retry *= 2
+			}
+			continue
+		}
+		retry = time.Second
+		if len(msgs) == 0 && wait == 0 {
+			// without a long poll, don't hammer an empty queue
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+		}
+
+		for i, msg := range msgs {
+			select {
+			case jobs <- msg:
+			case <-ctx.Done():
+				// nobody will handle the rest, let others have them now
+				for _, msg := range msgs[i:] {
+					c.release(msg, 0)
+				}
+				return
+			}
+		}
+	}
+}
+
+func (c *Consumer) handle(ctx context.Context, msg *Message) {
+	err := c.run(ctx, msg)
+	if err == nil {
+		if err := c.Queue.DeleteMessageContext(context.WithoutCancel(ctx), msg.Id); err != nil {
+			c.report(msg, err)
+		}
+		return
+	}
+
+	c.report(msg, err)
+	if ctx.Err() != nil {
+		// interrupted by Shutdown rather than failed, retry it right away
+		c.release(msg, 0)
+		return
+	}
+	backoff := c.Backoff
+	if backoff == nil {
+		backoff = DefaultBackoff
+	}
+	c.release(msg, backoff(msg.ReservedCount))
+}
+
+// run calls the handler, turning a panic into an error so it releases the
+// message rather than killing the process.
+func (c *Consumer) run(ctx context.Context, msg *Message) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("mq: handler panicked: %v", p)
+		}
+	}()
+	return c.Handler(ctx, msg)
+}
+
+func (c *Consumer) release(msg *Message, delay time.Duration) {
+	if err := c.Queue.ReleaseMessageContext(context.Background(), msg.Id, int64(seconds(delay))); err != nil {
+		c.report(msg, err)
+	}
+}
+
+// seconds rounds d up to whole seconds, so a short duration isn't taken as
+// none.
+func seconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+func (c *Consumer) report(msg *Message, err error) {
+	if c.OnError != nil {
+		c.OnError(msg, err)
+	}
+}
+
+// Shutdown stops reserving messages and waits for the handlers to finish,
+// releasing reserved messages that weren't handed to one. If ctx is done
+// first, the handlers' ctx is cancelled, their messages are released as they
+// return, and ctx's error is returned once they have. Handlers have to
+// honor their ctx for Shutdown to return.
+func (c *Consumer) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	if c.done == nil {
+		c.mu.Unlock()
+		return nil
+	}
+	close(c.stop)
+	done, cancel := c.done, c.cancel
+	c.mu.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		<-done
+		return ctx.Err()
+	}
+}
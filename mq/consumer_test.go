@@ -0,0 +1,197 @@
+package mq_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iron-io/iron_go/mq"
+	. "github.com/jeffh/go.bdd"
+)
+
+func TestConsumer(t *testing.T) {
+	defer PrintSpecReport()
+
+	Describe("Consumer", func() {
+		It("deletes the messages it handled", func() {
+			server := newFakeServer()
+			defer server.Close()
+			server.push("a", "b", "c", "d", "e")
+
+			mu := sync.Mutex{}
+			bodies := []string{}
+			handled := make(chan struct{}, 5)
+			c := mq.NewConsumer(server.queue("jobs"), func(ctx context.Context, msg *mq.Message) error {
+				mu.Lock()
+				bodies = append(bodies, msg.Body)
+				mu.Unlock()
+				handled <- struct{}{}
+				return nil
+			})
+			c.Concurrency = 3
+			c.Wait = time.Second
+			go c.Run(context.Background())
+			for i := 0; i < 5; i++ {
+				<-handled
+			}
+			Expect(c.Shutdown(context.Background()), ToBeNil)
+
+			sort.Strings(bodies)
+			Expect(bodies, ToDeepEqual, []string{"a", "b", "c", "d", "e"})
+			Expect(server.size(), ToEqual, 0)
+		})
+
+		It("releases failed messages with a backoff", func() {
+			server := newFakeServer()
+			defer server.Close()
+			server.push("flaky")
+
+			attempts := make(chan int64, 2)
+			failures := make(chan error, 1)
+			c := mq.NewConsumer(server.queue("jobs"), func(ctx context.Context, msg *mq.Message) error {
+				attempts <- msg.ReservedCount
+				if msg.ReservedCount == 1 {
+					return errors.New("try again")
+				}
+				return nil
+			})
+			c.Wait = time.Second
+			c.Backoff = func(reservedCount int64) time.Duration { return 0 }
+			c.OnError = func(msg *mq.Message, err error) { failures <- err }
+			go c.Run(context.Background())
+
+			Expect(<-attempts, ToEqual, int64(1))
+			Expect((<-failures).Error(), ToEqual, "try again")
+			Expect(<-attempts, ToEqual, int64(2))
+			Expect(c.Shutdown(context.Background()), ToBeNil)
+			Expect(server.releaseDelays("1"), ToDeepEqual, []int64{0})
+			Expect(server.deletedIds(), ToDeepEqual, []string{"1"})
+		})
+
+		It("rounds a sub-second backoff up to a second", func() {
+			server := newFakeServer()
+			defer server.Close()
+			server.push("flaky")
+
+			failures := make(chan error, 1)
+			c := mq.NewConsumer(server.queue("jobs"), func(ctx context.Context, msg *mq.Message) error {
+				return errors.New("try again")
+			})
+			c.Wait = time.Second
+			c.Backoff = func(reservedCount int64) time.Duration { return 200 * time.Millisecond }
+			c.OnError = func(msg *mq.Message, err error) { failures <- err }
+			go c.Run(context.Background())
+
+			Expect(<-failures, ToNotBeNil)
+			Expect(c.Shutdown(context.Background()), ToBeNil)
+			Expect(server.releaseDelays("1"), ToDeepEqual, []int64{1})
+		})
+
+		It("releases messages whose handler panicked", func() {
+			server := newFakeServer()
+			defer server.Close()
+			server.push("boom")
+
+			failures := make(chan error, 1)
+			c := mq.NewConsumer(server.queue("jobs"), func(ctx context.Context, msg *mq.Message) error {
+				panic("boom")
+			})
+			c.Wait = time.Second
+			c.Backoff = func(reservedCount int64) time.Duration { return time.Minute }
+			c.OnError = func(msg *mq.Message, err error) { failures <- err }
+			go c.Run(context.Background())
+
+			Expect(<-failures, ToNotBeNil)
+			Expect(c.Shutdown(context.Background()), ToBeNil)
+			Expect(server.releaseDelays("1"), ToDeepEqual, []int64{60})
+			Expect(server.size(), ToEqual, 1)
+		})
+
+		It("lets in-flight handlers finish on Shutdown", func() {
+			server := newFakeServer()
+			defer server.Close()
+			server.push("slow", "waiting")
+
+			started := make(chan struct{})
+			c := mq.NewConsumer(server.queue("jobs"), func(ctx context.Context, msg *mq.Message) error {
+				close(started)
+				time.Sleep(100 * time.Millisecond)
+				return ctx.Err()
+			})
+			c.BatchSize = 2
+			c.Wait = time.Second
+			ran := make(chan error, 1)
+			go func() { ran <- c.Run(context.Background()) }()
+			<-started
+
+			Expect(c.Shutdown(context.Background()), ToBeNil)
+			Expect(<-ran, ToBeNil)
+			Expect(server.deletedIds(), ToDeepEqual, []string{"1"})
+			// the second message of the batch never reached a handler
+			Expect(server.releaseDelays("2"), ToDeepEqual, []int64{0})
+			Expect(c.Run(context.Background()), ToEqual, mq.ErrConsumerClosed)
+		})
+
+		It("releases every reserved message still waiting for a worker", func() {
+			server := newFakeServer()
+			defer server.Close()
+			server.push("1", "2", "3", "4", "5")
+
+			started := make(chan struct{})
+			c := mq.NewConsumer(server.queue("jobs"), func(ctx context.Context, msg *mq.Message) error {
+				close(started)
+				time.Sleep(100 * time.Millisecond)
+				return nil
+			})
+			c.BatchSize = 5
+			c.Wait = 500 * time.Millisecond
+			go c.Run(context.Background())
+			<-started
+
+			Expect(c.Shutdown(context.Background()), ToBeNil)
+			Expect(server.deletedIds(), ToDeepEqual, []string{"1"})
+			for _, id := range []string{"2", "3", "4", "5"} {
+				Expect(server.releaseDelays(id), ToDeepEqual, []int64{0})
+			}
+			Expect(server.size(), ToEqual, 4)
+		})
+
+		It("cancels handlers once the Shutdown ctx is done", func() {
+			server := newFakeServer()
+			defer server.Close()
+			server.push("stuck")
+
+			started := make(chan struct{})
+			c := mq.NewConsumer(server.queue("jobs"), func(ctx context.Context, msg *mq.Message) error {
+				close(started)
+				<-ctx.Done()
+				return ctx.Err()
+			})
+			c.Wait = time.Second
+			go c.Run(context.Background())
+			<-started
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			Expect(c.Shutdown(ctx), ToEqual, context.DeadlineExceeded)
+			Expect(server.releaseDelays("1"), ToDeepEqual, []int64{0})
+			Expect(server.size(), ToEqual, 1)
+		})
+	})
+}
+
+func TestDefaultBackoff(t *testing.T) {
+	for reservedCount, want := range map[int64]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		4:  8 * time.Second,
+		40: 15 * time.Minute,
+	} {
+		if got := mq.DefaultBackoff(reservedCount); got != want {
+			t.Errorf("DefaultBackoff(%d) = %v, want %v", reservedCount, got, want)
+		}
+	}
+}
@@ -2,7 +2,9 @@
 package mq
 
 import (
+	"context"
 	"errors"
+	"net/http"
 	"time"
 
 	"github.com/iron-io/iron_go/api"
@@ -12,6 +14,9 @@ import (
 type Queue struct {
 	Settings config.Settings
 	Name     string
+	// HttpClient is used for the queue's requests instead of api.HttpClient
+	// when set.
+	HttpClient *http.Client
 }
 
 type QueueSubscriber struct {
@@ -77,6 +82,46 @@ func ConfigNew(queueName string, settings *config.Settings) Queue {
 	return Queue{Settings: config.ManualConfig("iron_mq", settings), Name: queueName}
 }
 
+// Option configures a Queue created by NewQueue.
+type Option func(*options)
+
+type options struct {
+	env      string
+	settings *config.Settings
+	client   *http.Client
+}
+
+// WithSettings merges the given settings over the ones found in iron.json
+// files and environment variables.
+func WithSettings(settings *config.Settings) Option {
+	return func(o *options) { o.settings = settings }
+}
+
+// WithClient makes requests using the given http.Client.
+func WithClient(client *http.Client) Option {
+	return func(o *options) { o.client = client }
+}
+
+// WithEnv picks the named environment out of iron.json files, see
+// config.ConfigWithEnv.
+func WithEnv(env string) Option {
+	return func(o *options) { o.env = env }
+}
+
+// NewQueue returns a Queue for queueName configured by the given options.
+// Unlike New, invalid configuration is returned as an error.
+func NewQueue(queueName string, opts ...Option) (*Queue, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	settings, err := config.Load("iron_mq", o.env, o.settings)
+	if err != nil {
+		return nil, err
+	}
+	return &Queue{Settings: settings, Name: queueName, HttpClient: o.client}, nil
+}
+
 func ListSettingsQueues(settings config.Settings, page int, perPage int) (queues []Queue, err error) {
 	out := []struct {
 		Id         string
@@ -84,8 +129,7 @@ func ListSettingsQueues(settings config.Settings, page int, perPage int) (queues
 		Name       string
 	}{}
 
-	q := New("")
-	q.Settings = settings
+	q := Queue{Settings: settings}
 	err = q.queues().
 		QueryAdd("page", "%d", page).
 		QueryAdd("per_page", "%d", perPage).
@@ -117,7 +161,11 @@ func ListQueues(page, perPage int) (queues []Queue, err error) {
 	return ListProjectQueues(settings.ProjectId, settings.Token, page, perPage)
 }
 
-func (q Queue) queues(s ...string) *api.URL { return api.Action(q.Settings, "queues", s...) }
+func (q Queue) queues(s ...string) *api.URL {
+	u := api.Action(q.Settings, "queues", s...)
+	u.HttpClient = q.HttpClient
+	return u
+}
 
 // This method is left to support backward compatibility.
 // This method is replaced by func ListQueues(page, perPage int) (queues []Queue, err error)
@@ -231,25 +279,42 @@ func (q Queue) Get() (msg *Message, err error) {
 	return
 }
 
-// get N messages
+// get N messages, waiting up to the configured LongPollWait for them to arrive
 func (q Queue) GetN(n int) (msgs []*Message, err error) {
-	return q.GetNWithTimeoutAndWait(n, 0, 0)
+	return q.GetNWithTimeoutAndWait(n, 0, q.longPollWait())
 }
 
 func (q Queue) GetNWithTimeout(n, timeout int) (msgs []*Message, err error) {
-	return q.GetNWithTimeoutAndWait(n, timeout, 0)
+	return q.GetNWithTimeoutAndWait(n, timeout, q.longPollWait())
+}
+
+func (q Queue) longPollWait() int {
+	return int(q.Settings.LongPollWait / time.Second)
 }
 
 func (q Queue) GetNWithTimeoutAndWait(n, timeout, wait int) (msgs []*Message, err error) {
+	return q.GetNWithTimeoutAndWaitContext(context.Background(), n, timeout, wait)
+}
+
+// GetNWithTimeoutAndWaitContext is like GetNWithTimeoutAndWait, but gives up
+// when ctx is done, even while waiting for messages to arrive.
+func (q Queue) GetNWithTimeoutAndWaitContext(ctx context.Context, n, timeout, wait int) (msgs []*Message, err error) {
 	out := struct {
 		Messages []*Message `json:"messages"`
 	}{}
 
-	err = q.queues(q.Name, "messages").
+	// the server holds long polls open, don't let the request timeout cut
+	// them short.
+	lq := q
+	if lq.Settings.Timeout > 0 {
+		lq.Settings.Timeout += time.Duration(wait) * time.Second
+	}
+
+	err = lq.queues(q.Name, "messages").
 		QueryAdd("n", "%d", n).
 		QueryAdd("timeout", "%d", timeout).
 		QueryAdd("wait", "%d", wait).
-		Req("GET", nil, &out)
+		ReqContext(ctx, "GET", nil, &out)
 	if err != nil {
 		return
 	}
@@ -310,7 +375,12 @@ func (q Queue) Clear() (err error) {
 
 // Delete message from queue
 func (q Queue) DeleteMessage(msgId string) (err error) {
-	return q.queues(q.Name, "messages", msgId).Req("DELETE", nil, nil)
+	return q.DeleteMessageContext(context.Background(), msgId)
+}
+
+// DeleteMessageContext is like DeleteMessage, but gives up when ctx is done.
+func (q Queue) DeleteMessageContext(ctx context.Context, msgId string) (err error) {
+	return q.queues(q.Name, "messages", msgId).ReqContext(ctx, "DELETE", nil, nil)
 }
 
 func (q Queue) DeleteMessages(messages []*Message) error {
@@ -334,10 +404,16 @@ func (q Queue) TouchMessage(msgId string) (err error) {
 
 // Put message back in the queue, message will be available after +delay+ seconds.
 func (q Queue) ReleaseMessage(msgId string, delay int64) (err error) {
+	return q.ReleaseMessageContext(context.Background(), msgId, delay)
+}
+
+// ReleaseMessageContext is like ReleaseMessage, but gives up when ctx is
+// done.
+func (q Queue) ReleaseMessageContext(ctx context.Context, msgId string, delay int64) (err error) {
 	in := struct {
 		Delay int64 `json:"delay"`
 	}{Delay: delay}
-	return q.queues(q.Name, "messages", msgId, "release").Req("POST", &in, nil)
+	return q.queues(q.Name, "messages", msgId, "release").ReqContext(ctx, "POST", &in, nil)
 }
 
 func (q Queue) MessageSubscribers(msgId string) ([]*Subscriber, error) {
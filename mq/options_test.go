@@ -0,0 +1,55 @@
+package mq_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/iron-io/iron_go/mq"
+	. "github.com/jeffh/go.bdd"
+)
+
+// recorder is an http.RoundTripper noting the Authorization of each request
+// it passes on.
+type recorder struct {
+	sync.Mutex
+	auth []string
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.Lock()
+	r.auth = append(r.auth, req.Header.Get("Authorization"))
+	r.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestNewQueue(t *testing.T) {
+	defer PrintSpecReport()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	os.WriteFile(filepath.Join(home, ".iron.json"), []byte(`{"staging": {"token": "staging-token"}}`), 0600)
+
+	Describe("NewQueue", func() {
+		It("makes requests with the given settings, environment and client", func() {
+			server := newFakeServer()
+			defer server.Close()
+			server.push("a")
+
+			settings := server.queue("jobs").Settings
+			settings.Token = ""
+			rec := &recorder{}
+			q, err := mq.NewQueue("jobs", mq.WithSettings(&settings), mq.WithEnv("staging"), mq.WithClient(&http.Client{Transport: rec}))
+			Expect(err, ToBeNil)
+			Expect(q.Settings.Host, ToEqual, settings.Host)
+			Expect(q.Settings.Token, ToEqual, "staging-token")
+
+			msg, err := q.Get()
+			Expect(err, ToBeNil)
+			Expect(msg.Body, ToEqual, "a")
+			Expect(rec.auth, ToDeepEqual, []string{"OAuth staging-token"})
+		})
+	})
+}
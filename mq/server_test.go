@@ -0,0 +1,174 @@
+package mq_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iron-io/iron_go/config"
+	"github.com/iron-io/iron_go/mq"
+)
+
+// fakeServer is an in-memory stand-in for the IronMQ API, covering what a
+// Consumer needs: reserving, deleting and releasing messages.
+type fakeServer struct {
+	*httptest.Server
+	sync.Mutex
+	messages []*fakeMessage
+	nextId   int
+	deleted  []string
+	releases map[string][]int64
+}
+
+type fakeMessage struct {
+	id            string
+	body          string
+	reservedUntil time.Time
+	reservedCount int64
+}
+
+func newFakeServer() *fakeServer {
+	s := &fakeServer{releases: map[string][]int64{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// queue returns a Queue talking to the fake server.
+func (s *fakeServer) queue(name string) *mq.Queue {
+	host, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return &mq.Queue{
+		Name: name,
+		Settings: config.Settings{
+			Token:      "token",
+			ProjectId:  "4f2a7c1b9e8d6f3a2b1c0d9e",
+			Host:       host,
+			Port:       uint16(portNum),
+			Scheme:     "http",
+			ApiVersion: "1",
+		},
+	}
+}
+
+// push adds messages with the given bodies.
+func (s *fakeServer) push(bodies ...string) {
+	s.Lock()
+	defer s.Unlock()
+	for _, body := range bodies {
+		s.nextId++
+		s.messages = append(s.messages, &fakeMessage{id: strconv.Itoa(s.nextId), body: body})
+	}
+}
+
+// size is how many messages weren't deleted yet.
+func (s *fakeServer) size() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.messages)
+}
+
+func (s *fakeServer) deletedIds() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string{}, s.deleted...)
+}
+
+func (s *fakeServer) releaseDelays(id string) []int64 {
+	s.Lock()
+	defer s.Unlock()
+	return append([]int64{}, s.releases[id]...)
+}
+
+func (s *fakeServer) reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *fakeServer) serve(w http.ResponseWriter, r *http.Request) {
+	// /1/projects/{id}/queues/{queue}/messages[/{id}[/release]]
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) < 6 || parts[3] != "queues" || parts[5] != "messages" {
+		s.reply(w, http.StatusNotFound, map[string]string{"msg": "Not found"})
+		return
+	}
+	parts = parts[6:]
+
+	switch {
+	case len(parts) == 0 && r.Method == "GET":
+		s.reserve(w, r)
+	case len(parts) == 1 && r.Method == "DELETE":
+		s.Lock()
+		defer s.Unlock()
+		for i, msg := range s.messages {
+			if msg.id == parts[0] {
+				s.messages = append(s.messages[:i], s.messages[i+1:]...)
+				s.deleted = append(s.deleted, msg.id)
+				s.reply(w, http.StatusOK, map[string]string{"msg": "Deleted"})
+				return
+			}
+		}
+		s.reply(w, http.StatusNotFound, map[string]string{"msg": "Message not found"})
+	case len(parts) == 2 && parts[1] == "release" && r.Method == "POST":
+		in := struct{ Delay int64 }{}
+		json.NewDecoder(r.Body).Decode(&in)
+		s.Lock()
+		defer s.Unlock()
+		for _, msg := range s.messages {
+			if msg.id == parts[0] {
+				msg.reservedUntil = time.Now().Add(time.Duration(in.Delay) * time.Second)
+				s.releases[msg.id] = append(s.releases[msg.id], in.Delay)
+				s.reply(w, http.StatusOK, map[string]string{"msg": "Released"})
+				return
+			}
+		}
+		s.reply(w, http.StatusNotFound, map[string]string{"msg": "Message not found"})
+	default:
+		s.reply(w, http.StatusNotFound, map[string]string{"msg": "Not found"})
+	}
+}
+
+// reserve hands out up to n available messages, waiting for up to wait
+// seconds for the first to become available.
+func (s *fakeServer) reserve(w http.ResponseWriter, r *http.Request) {
+	n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+	timeout, _ := strconv.Atoi(r.URL.Query().Get("timeout"))
+	wait, _ := strconv.Atoi(r.URL.Query().Get("wait"))
+	if timeout == 0 {
+		timeout = 60
+	}
+	deadline := time.Now().Add(time.Duration(wait) * time.Second)
+
+	for {
+		s.Lock()
+		out := []*mq.Message{}
+		now := time.Now()
+		for _, msg := range s.messages {
+			if len(out) == n {
+				break
+			}
+			if now.Before(msg.reservedUntil) {
+				continue
+			}
+			msg.reservedUntil = now.Add(time.Duration(timeout) * time.Second)
+			msg.reservedCount++
+			out = append(out, &mq.Message{Id: msg.id, Body: msg.body, ReservedCount: msg.reservedCount})
+		}
+		s.Unlock()
+
+		if len(out) > 0 || !now.Before(deadline) {
+			s.reply(w, http.StatusOK, map[string]interface{}{"messages": out})
+			return
+		}
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
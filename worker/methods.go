@@ -127,9 +127,11 @@ func (w *Worker) CodePackageList(page, perPage int) (codes []CodeInfo, err error
 	return out["codes"], nil
 }
 
-// CodePackageUpload uploads a code package
+// CodePackageUpload uploads a code package. Like every other request it's
+// sent with the Worker's HttpClient, or api.HttpClient if that's nil, so
+// their timeout and transport apply to uploads too.
 func (w *Worker) CodePackageUpload(code Code) (id string, err error) {
-	client := http.Client{}
+	url := w.codes()
 
 	body := &bytes.Buffer{}
 	mWriter := multipart.NewWriter(body)
@@ -173,7 +175,7 @@ func (w *Worker) CodePackageUpload(code Code) (id string, err error) {
 	// done with multipart
 	mWriter.Close()
 
-	req, err := http.NewRequest("POST", w.codes().URL.String(), body)
+	req, err := http.NewRequest("POST", url.URL.String(), body)
 	if err != nil {
 		return
 	}
@@ -185,7 +187,7 @@ func (w *Worker) CodePackageUpload(code Code) (id string, err error) {
 	req.Header.Set("User-Agent", w.Settings.UserAgent)
 
 	// dumpRequest(req) NOTE: never do this here, it breaks stuff
-	response, err := client.Do(req)
+	response, err := url.Client().Do(req)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,70 @@
+package worker_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/iron-io/iron_go/config"
+	"github.com/iron-io/iron_go/worker"
+	. "github.com/jeffh/go.bdd"
+)
+
+// recorder is an http.RoundTripper noting the method and Authorization of
+// each request it passes on.
+type recorder struct {
+	sync.Mutex
+	requests []string
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.Lock()
+	r.requests = append(r.requests, req.Method+" "+req.Header.Get("Authorization"))
+	r.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestNewWorker(t *testing.T) {
+	defer PrintSpecReport()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	os.WriteFile(filepath.Join(home, ".iron.json"), []byte(`{"staging": {"token": "staging-token"}}`), 0600)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.Write([]byte(`{"id": "code-1", "msg": "Upload successful."}`))
+			return
+		}
+		w.Write([]byte(`{"codes": []}`))
+	}))
+	defer ts.Close()
+	host, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	settings := config.Settings{
+		ProjectId: "4f2a7c1b9e8d6f3a2b1c0d9e",
+		Host:      host, Port: uint16(portNum), Scheme: "http", ApiVersion: "2",
+	}
+
+	Describe("NewWorker", func() {
+		It("makes requests, uploads included, with the given settings, environment and client", func() {
+			rec := &recorder{}
+			w, err := worker.NewWorker(worker.WithSettings(&settings), worker.WithEnv("staging"), worker.WithClient(&http.Client{Transport: rec}))
+			Expect(err, ToBeNil)
+			Expect(w.Settings.Host, ToEqual, host)
+			Expect(w.Settings.Token, ToEqual, "staging-token")
+
+			_, err = w.CodePackageList(0, 10)
+			Expect(err, ToBeNil)
+			id, err := w.CodePackageUpload(worker.Code{Name: "hello", Runtime: "go", FileName: "main.go", Source: worker.CodeSource{"main.go": []byte("package main")}})
+			Expect(err, ToBeNil)
+			Expect(id, ToEqual, "code-1")
+			Expect(rec.requests, ToDeepEqual, []string{"GET OAuth staging-token", "POST OAuth staging-token"})
+		})
+	})
+}
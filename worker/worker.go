@@ -2,6 +2,7 @@
 package worker
 
 import (
+	"net/http"
 	"time"
 
 	"github.com/iron-io/iron_go/api"
@@ -10,15 +11,64 @@ import (
 
 type Worker struct {
 	Settings config.Settings
+	// HttpClient is used for the worker's requests instead of api.HttpClient
+	// when set.
+	HttpClient *http.Client
 }
 
 func New() *Worker {
 	return &Worker{Settings: config.Config("iron_worker")}
 }
 
-func (w *Worker) codes(s ...string) *api.URL     { return api.Action(w.Settings, "codes", s...) }
-func (w *Worker) tasks(s ...string) *api.URL     { return api.Action(w.Settings, "tasks", s...) }
-func (w *Worker) schedules(s ...string) *api.URL { return api.Action(w.Settings, "schedules", s...) }
+// Option configures a Worker created by NewWorker.
+type Option func(*options)
+
+type options struct {
+	env      string
+	settings *config.Settings
+	client   *http.Client
+}
+
+// WithSettings merges the given settings over the ones found in iron.json
+// files and environment variables.
+func WithSettings(settings *config.Settings) Option {
+	return func(o *options) { o.settings = settings }
+}
+
+// WithClient makes requests using the given http.Client.
+func WithClient(client *http.Client) Option {
+	return func(o *options) { o.client = client }
+}
+
+// WithEnv picks the named environment out of iron.json files, see
+// config.ConfigWithEnv.
+func WithEnv(env string) Option {
+	return func(o *options) { o.env = env }
+}
+
+// NewWorker returns a Worker configured by the given options.
+// Unlike New, invalid configuration is returned as an error.
+func NewWorker(opts ...Option) (*Worker, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	settings, err := config.Load("iron_worker", o.env, o.settings)
+	if err != nil {
+		return nil, err
+	}
+	return &Worker{Settings: settings, HttpClient: o.client}, nil
+}
+
+func (w *Worker) codes(s ...string) *api.URL     { return w.action("codes", s...) }
+func (w *Worker) tasks(s ...string) *api.URL     { return w.action("tasks", s...) }
+func (w *Worker) schedules(s ...string) *api.URL { return w.action("schedules", s...) }
+
+func (w *Worker) action(prefix string, s ...string) *api.URL {
+	u := api.Action(w.Settings, prefix, s...)
+	u.HttpClient = w.HttpClient
+	return u
+}
 
 // exponential sleep between retries, replace this with your own preferred strategy
 func sleepBetweenRetries(previousDuration time.Duration) time.Duration {